@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// commandLineFn returns the command name followed by the arguments, which
+// are either the arguments of golisp itself or of the script.
+func commandLineFn(arg Value, interp Interp) (Value, error) {
+	if _, err := interp.RangeArgs(arg, "command-line", 0, 0); err != nil {
+		return ValueNull(), err
+	}
+	items := make([]Value, len(interp.CommandLine))
+	for i, s := range interp.CommandLine {
+		items[i] = Value{Type: ValString, StringData: s}
+	}
+	return NewList(items), nil
+}
+
+type optionSpec struct {
+	name    string
+	initial Value
+	// flag options take no value and become #t when given
+	flag bool
+}
+
+func (self Interp) optionSpecs(spec Value) ([]optionSpec, error) {
+	entries, err := self.RangeArgs(spec, "parse-args", 0, MaxArgs)
+	if err != nil {
+		return nil, err
+	}
+	var specs []optionSpec
+	for _, entry := range entries {
+		items, err := self.RangeArgs(entry, "parse-args", 3, 3)
+		if err != nil {
+			return nil, err
+		}
+		kind := items[2].Symbol
+		if items[0].Type != ValSymbol || items[2].Type != ValSymbol || (kind != "flag" && kind != "value") {
+			_, err := self.NewEvalError(entry, fmt.Sprintf(
+				"`parse-args` expects option spec (name default flag|value), given: %v", entry))
+			return nil, err
+		}
+		specs = append(specs, optionSpec{items[0].Symbol, items[1], kind == "flag"})
+	}
+	return specs, nil
+}
+
+// parseArgsFn parses the command line in a list of strings according to the
+// spec, which is a list of (name default kind) entries. Options are given as
+// "--name" for flags and as "--name value" or "--name=value" for values, "--"
+// ends the options. The first element is the command name, like in the
+// result of `command-line`, and it is skipped. Returns the pair of the alist of
+// options in the order of the spec and the list of positional arguments.
+func parseArgsFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "parse-args", 2, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	words, err := interp.RangeArgs(args[0], "parse-args", 0, MaxArgs)
+	if err != nil {
+		return ValueNull(), err
+	}
+	specs, err := interp.optionSpecs(args[1])
+	if err != nil {
+		return ValueNull(), err
+	}
+	values := make([]Value, len(specs))
+	for i, spec := range specs {
+		values[i] = spec.initial
+	}
+	var positional []Value
+	for i := 1; i < len(words); i++ {
+		word := words[i]
+		if word.Type != ValString {
+			return interp.NewEvalErrorOf(ErrWrongType, "parse-args", interp.Call, fmt.Sprintf(
+				"`parse-args` expects list of ValString arguments, given: %v", word))
+		}
+		if word.StringData == "--" {
+			positional = append(positional, words[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(word.StringData, "--") {
+			positional = append(positional, word)
+			continue
+		}
+		name, value := strings.TrimPrefix(word.StringData, "--"), ""
+		hasValue := false
+		if j := strings.IndexByte(name, '='); j >= 0 {
+			name, value, hasValue = name[:j], name[j+1:], true
+		}
+		index := -1
+		for j, spec := range specs {
+			if spec.name == name {
+				index = j
+			}
+		}
+		if index < 0 {
+			return interp.NewEvalError(interp.Call, fmt.Sprintf(
+				"`parse-args` unknown option: %v", word.StringData))
+		}
+		if specs[index].flag {
+			if hasValue {
+				return interp.NewEvalError(interp.Call, fmt.Sprintf(
+					"`parse-args` option --%v takes no value, given: %v", name, word.StringData))
+			}
+			values[index] = Value{Type: ValBool, Bool: true}
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(words) || words[i+1].Type != ValString {
+				return interp.NewEvalError(interp.Call, fmt.Sprintf(
+					"`parse-args` option --%v expects a value", name))
+			}
+			i++
+			value = words[i].StringData
+		}
+		values[index] = Value{Type: ValString, StringData: value}
+	}
+	alist := make([]Value, len(specs))
+	for i, spec := range specs {
+		name, value := Value{Type: ValSymbol, Symbol: spec.name}, values[i]
+		alist[i] = *NewNode(&name, &value)
+	}
+	options, rest := NewList(alist), NewList(positional)
+	return *NewNode(&options, &rest), nil
+}
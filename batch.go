@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Limits bound the evaluation of every top level form in batch mode, a form
+// exceeding them fails with ErrLimit error and the next form is evaluated.
+type Limits struct {
+	// MaxSteps is the number of Interp.Eval calls allowed, zero for no limit
+	MaxSteps int64
+	// Timeout is the wall time allowed, zero for no limit. Builtins blocked on
+	// input are not interrupted, except waiting for channels, `http-get/retry`
+	// and rate limiters.
+	Timeout  time.Duration
+	steps    int64
+	deadline time.Time
+}
+
+// limitsClockPeriod is the number of steps between looking at the clock,
+// which is too slow to do on every step.
+const limitsClockPeriod = 1024
+
+// Reset starts counting the steps and the time of the next form.
+func (self *Limits) Reset() {
+	self.steps = 0
+	self.deadline = time.Now().Add(self.Timeout)
+}
+
+// countStep accounts evaluation of the expression, the error is located at
+// the expression the limit is exceeded at.
+func (self *Interp) countStep(expression Value) error {
+	limits := self.Limits
+	limits.steps++
+	if limits.MaxSteps > 0 && limits.steps > limits.MaxSteps {
+		_, err := self.NewEvalErrorOf(ErrLimit, "steps", expression, fmt.Sprintf(
+			"Step limit exceeded, the form took more than %v steps", limits.MaxSteps))
+		return err
+	}
+	if limits.Timeout > 0 && limits.steps%limitsClockPeriod == 0 && time.Now().After(limits.deadline) {
+		_, err := self.NewEvalErrorOf(ErrLimit, "time", expression, fmt.Sprintf(
+			"Time limit exceeded, the form took more than %v", limits.Timeout))
+		return err
+	}
+	return nil
+}
+
+// RunBatch evaluates the file form by form, the rest of the arguments are
+// the arguments of the script, see `command-line`. Errors are reported and
+// the evaluation continues with the next form, so one broken exercise does not
+// hide the results of the others. Returns the exit code, which is 1 if any
+// form has failed.
+func RunBatch(path string, args []string, options Options) int {
+	interpreter := NewInterp()
+	interpreter.CommandLine = append([]string{path}, args...)
+	interpreter.Output = StdoutPort
+	closer := ConfigureInterp(&interpreter, options, os.Stderr)
+	defer closer()
+	if options.MaxSteps > 0 || options.FormTimeout > 0 {
+		interpreter.Limits = &Limits{MaxSteps: options.MaxSteps, Timeout: options.FormTimeout}
+	}
+	var records *RecordWriter
+	if options.Output == "json" {
+		records = NewRecordWriter(os.Stdout, &interpreter, options.Teach)
+	}
+	parser, expressions, err := ParseFile(path, options.FoldCase)
+	if err != nil && records != nil {
+		records.Write("", "", nil, err)
+		return 1
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Parsing error: %s\n", err.Error())
+		if options.Teach {
+			fmt.Fprint(os.Stderr, ExplainError(err))
+		}
+		return 1
+	}
+	interpreter.Register(&parser.Lex)
+	code := 0
+	for _, expression := range expressions {
+		if interpreter.Limits != nil {
+			interpreter.Limits.Reset()
+		}
+		result, err := interpreter.Eval(expression)
+		if err != nil {
+			code = 1
+		}
+		if records != nil {
+			var printed *string
+			if err == nil {
+				text := PrinterFromInterp(interpreter).Sprint(result)
+				printed = &text
+			}
+			records.Write(FormText(&parser.Lex, expression), "", printed, err)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Eval error: %s\n", err.Error())
+			if options.Teach {
+				fmt.Fprint(os.Stderr, ExplainError(err))
+			}
+		}
+	}
+	return code
+}
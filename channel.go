@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Channel passes values between goroutines, like the reader of a file and the
+// code processing what is read. Closing the channel ends it for both sides:
+// the values put already are still taken, then `channel-get` returns the end
+// of file object. Nothing panics on a closed channel, unlike Go.
+//
+// Lisp code runs in a single goroutine, so it never waits for itself: putting
+// into a full channel fails, and so does taking from an empty one, unless a
+// producer, like the goroutine of `port->channel`, is going to put more.
+type Channel struct {
+	values chan Value
+	closed chan struct{}
+	once   sync.Once
+	// err is the reason the channel is closed for, if it is not the end of
+	// the input, see `port->channel`
+	err error
+	// producers is the count of goroutines putting into the channel, see
+	// StartProducer
+	producers int32
+}
+
+// defaultChannelCapacity is the capacity of `(make-channel)`.
+const defaultChannelCapacity = 64
+
+var (
+	errChannelClosed = errors.New("channel is closed")
+	errChannelFull   = errors.New("channel is full and nothing takes from it")
+	errChannelEmpty  = errors.New("channel is empty and nothing puts into it")
+	errChannelDone   = errors.New("waiting for channel is canceled")
+)
+
+func NewChannel(capacity int) *Channel {
+	return &Channel{values: make(chan Value, capacity), closed: make(chan struct{})}
+}
+
+// StartProducer tells that a goroutine is going to put into the channel, so
+// taking from the channel waits for it. The producer calls StopProducer when
+// it is done, after closing the channel if it ends the channel.
+func (self *Channel) StartProducer() {
+	atomic.AddInt32(&self.producers, 1)
+}
+
+func (self *Channel) StopProducer() {
+	atomic.AddInt32(&self.producers, -1)
+}
+
+func (self *Channel) producing() bool {
+	return atomic.LoadInt32(&self.producers) > 0
+}
+
+// Close closes the channel with the error, nil for the normal end. Only the
+// first close counts.
+func (self *Channel) Close(err error) {
+	self.once.Do(func() {
+		self.err = err
+		close(self.closed)
+	})
+}
+
+// Put sends the value. It waits for the room in the channel if wait is set,
+// until the channel is closed or done is closed, and fails with
+// errChannelFull otherwise.
+func (self *Channel) Put(v Value, wait bool, done <-chan struct{}) error {
+	select {
+	case <-self.closed:
+		return errChannelClosed
+	default:
+	}
+	select {
+	case self.values <- v:
+		return nil
+	default:
+	}
+	if !wait {
+		return errChannelFull
+	}
+	select {
+	case self.values <- v:
+		return nil
+	case <-self.closed:
+		return errChannelClosed
+	case <-done:
+		return errChannelDone
+	}
+}
+
+// Get receives the next value, it returns io.EOF when the channel is closed
+// and drained. An empty channel is waited for only if a producer is going to
+// put into it, until done is closed, and it fails with errChannelEmpty
+// otherwise.
+func (self *Channel) Get(done <-chan struct{}) (Value, error) {
+	select {
+	case v := <-self.values:
+		return v, nil
+	default:
+	}
+	select {
+	case <-self.closed:
+		return self.drained()
+	default:
+	}
+	if !self.producing() {
+		return ValueNull(), errChannelEmpty
+	}
+	select {
+	case v := <-self.values:
+		return v, nil
+	case <-self.closed:
+		return self.drained()
+	case <-done:
+		return ValueNull(), errChannelDone
+	}
+}
+
+// drained returns the values put just before the close, then io.EOF.
+func (self *Channel) drained() (Value, error) {
+	select {
+	case v := <-self.values:
+		return v, nil
+	default:
+		return ValueNull(), io.EOF
+	}
+}
+
+// waitContext is done when the evaluation is canceled or the time limit of
+// the form is exceeded, so that waiting for a channel or a timer ends too,
+// see Limits.
+func (self Interp) waitContext() (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if self.Yielder != nil && self.Yielder.Context != nil {
+		ctx = self.Yielder.Context
+	}
+	if self.Limits != nil && self.Limits.Timeout > 0 {
+		return context.WithDeadline(ctx, self.Limits.deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+// canceledError reports the waiting of the procedure ended by the context of
+// waitContext.
+func (self Interp) canceledError(name string, ctx context.Context) (Value, error) {
+	if ctx.Err() == context.DeadlineExceeded && self.Limits != nil && self.Limits.Timeout > 0 {
+		return self.NewEvalErrorOf(ErrLimit, "time", self.Call, fmt.Sprintf(
+			"Time limit exceeded waiting for `%v`, the form took more than %v", name, self.Limits.Timeout))
+	}
+	return self.NewEvalErrorOf(ErrCanceled, "", self.Call, fmt.Sprintf(
+		"Evaluation canceled waiting for `%v`: %v", name, ctx.Err()))
+}
+
+// channelError reports the failure of Put or Get, ctx is the context waited
+// with.
+func (self Interp) channelError(name string, err error, ctx context.Context) (Value, error) {
+	switch {
+	case err == errChannelDone:
+		return self.canceledError(name, ctx)
+	case err == errChannelFull || err == errChannelEmpty:
+		return self.NewEvalError(self.Call, fmt.Sprintf(
+			"`%v` would wait forever: the %v", name, err))
+	}
+	return self.NewEvalError(self.Call, fmt.Sprintf("`%v` failed: %v", name, err))
+}
+
+func (self Interp) channelArg(v Value, name string) (*Channel, error) {
+	if v.Type != ValChannel {
+		_, err := self.NewEvalErrorOf(ErrWrongType, name, v, fmt.Sprintf(
+			"`%v` expects channel, given: %v", name, v))
+		return nil, err
+	}
+	return v.Channel, nil
+}
+
+// makeChannelFn handles `(make-channel [capacity])`, the capacity is the
+// count of values put and not taken yet the channel holds.
+func makeChannelFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "make-channel", 0, 1)
+	if err != nil {
+		return ValueNull(), err
+	}
+	capacity := defaultChannelCapacity
+	if len(args) == 1 {
+		if args[0].Type != ValNumber || args[0].Number < 1 {
+			return interp.NewEvalErrorOf(ErrWrongType, "make-channel", args[0], fmt.Sprintf(
+				"`make-channel` expects positive capacity, given: %v", args[0]))
+		}
+		capacity = args[0].Number
+	}
+	return Value{Type: ValChannel, Channel: NewChannel(capacity)}, nil
+}
+
+func channelPredicateFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "channel?")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return Value{Type: ValBool, Bool: value.Type == ValChannel}, nil
+}
+
+// channelPutFn handles `(channel-put! channel value)`. Putting into a closed
+// or a full channel is an error, since there is no one else to take from it.
+func channelPutFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "channel-put!", 2, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	channel, err := interp.channelArg(args[0], "channel-put!")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if err := channel.Put(args[1], false, nil); err != nil {
+		return interp.channelError("channel-put!", err, context.Background())
+	}
+	return ValueNull(), nil
+}
+
+// channelGetFn handles `(channel-get channel)`, which returns the end of file
+// object once the channel is closed and drained. The error the channel is
+// closed with is reported instead, if there is one.
+func channelGetFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "channel-get")
+	if err != nil {
+		return ValueNull(), err
+	}
+	channel, err := interp.channelArg(value, "channel-get")
+	if err != nil {
+		return ValueNull(), err
+	}
+	ctx, cancel := interp.waitContext()
+	defer cancel()
+	v, err := channel.Get(ctx.Done())
+	switch {
+	case err == io.EOF && channel.err != nil:
+		return interp.NewEvalError(interp.Call, fmt.Sprintf(
+			"`channel-get` from channel closed by error: %v", channel.err))
+	case err == io.EOF:
+		return Value{Type: ValEOF}, nil
+	case err != nil:
+		return interp.channelError("channel-get", err, ctx)
+	}
+	return v, nil
+}
+
+func closeChannelFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "close-channel")
+	if err != nil {
+		return ValueNull(), err
+	}
+	channel, err := interp.channelArg(value, "close-channel")
+	if err != nil {
+		return ValueNull(), err
+	}
+	channel.Close(nil)
+	return ValueNull(), nil
+}
+
+// channelReader reads the values of the channel as text: strings are taken
+// as they are, and the other values are written followed by a space, so that
+// the datums put into the channel are read back by `read`.
+type channelReader struct {
+	channel *Channel
+	// interp is the interpreter reading, its limits end the waiting
+	interp  Interp
+	pending string
+}
+
+func (self *channelReader) Read(p []byte) (int, error) {
+	for self.pending == "" {
+		v, err := self.get()
+		if err != nil {
+			return 0, err
+		}
+		if v.Type == ValString {
+			self.pending = v.StringData
+		} else {
+			self.pending = Printer{}.Sprint(v) + " "
+		}
+	}
+	n := copy(p, self.pending)
+	self.pending = self.pending[n:]
+	return n, nil
+}
+
+func (self *channelReader) get() (Value, error) {
+	ctx, cancel := self.interp.waitContext()
+	defer cancel()
+	v, err := self.channel.Get(ctx.Done())
+	switch {
+	case err == io.EOF && self.channel.err != nil:
+		return v, self.channel.err
+	case err == errChannelDone:
+		return v, fmt.Errorf("%v: %v", err, ctx.Err())
+	}
+	return v, err
+}
+
+// channelWriter puts everything written as strings into the channel.
+type channelWriter struct {
+	channel *Channel
+}
+
+func (self channelWriter) Write(p []byte) (int, error) {
+	if err := self.channel.Put(Value{Type: ValString, StringData: string(p)}, false, nil); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+type channelCloser struct {
+	channel *Channel
+}
+
+func (self channelCloser) Close() error {
+	self.channel.Close(nil)
+	return nil
+}
+
+// channelPortFn handles `(channel->port channel)`, which makes the port both
+// reading from and writing to the channel, see channelReader and
+// channelWriter. Closing the port closes the channel.
+func channelPortFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "channel->port")
+	if err != nil {
+		return ValueNull(), err
+	}
+	channel, err := interp.channelArg(value, "channel->port")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return Value{Type: ValPort, Port: &Port{
+		Name:   "channel",
+		Input:  &channelReader{channel: channel, interp: interp},
+		Output: channelWriter{channel},
+		Closer: channelCloser{channel},
+	}}, nil
+}
+
+// portChannelFn handles `(port->channel port chunk-size)`, which starts a
+// goroutine putting the input of the port into a new channel as strings of
+// at most chunk-size bytes. The channel is closed at the end of the input,
+// or with the error reading fails with. Closing the channel stops the
+// goroutine, the port is not to be read by anything else meanwhile.
+func portChannelFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "port->channel", 2, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	port, err := interp.InputPortArg(args[0], "port->channel")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if args[1].Type != ValNumber || args[1].Number < 1 {
+		return interp.NewEvalErrorOf(ErrWrongType, "port->channel", args[1], fmt.Sprintf(
+			"`port->channel` expects positive chunk size, given: %v", args[1]))
+	}
+	channel := NewChannel(defaultChannelCapacity)
+	channel.StartProducer()
+	go func(input io.Reader, buffer []byte) {
+		defer channel.StopProducer()
+		for {
+			n, err := input.Read(buffer)
+			if n > 0 && channel.Put(Value{Type: ValString, StringData: string(buffer[:n])}, true, nil) != nil {
+				return
+			}
+			if err == io.EOF {
+				channel.Close(nil)
+				return
+			} else if err != nil {
+				channel.Close(fmt.Errorf("reading from port %v failed: %v", port.Name, err))
+				return
+			}
+		}
+	}(port.Input, make([]byte, args[1].Number))
+	return Value{Type: ValChannel, Channel: channel}, nil
+}
+
+func eofObjectFn(arg Value, interp Interp) (Value, error) {
+	if _, err := interp.RangeArgs(arg, "eof-object", 0, 0); err != nil {
+		return ValueNull(), err
+	}
+	return Value{Type: ValEOF}, nil
+}
+
+func eofObjectPredicateFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "eof-object?")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return Value{Type: ValBool, Bool: value.Type == ValEOF}, nil
+}
+
+// readFn handles `(read port)`, which parses the next datum of the input port
+// and returns the end of file object after the last one. The parser is kept
+// in the port, so the datums are located in the text read so far.
+func readFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "read")
+	if err != nil {
+		return ValueNull(), err
+	}
+	port, err := interp.InputPortArg(value, "read")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if port.parser == nil {
+		port.parser = &Pars{}
+		port.parser.Lex.Name = port.Name
+	}
+	datum, err := port.parser.ParseNext(port.Input)
+	if err == io.EOF {
+		return Value{Type: ValEOF}, nil
+	} else if err != nil {
+		if _, ok := err.(Error); ok {
+			return ValueNull(), err
+		}
+		return interp.NewEvalError(value, fmt.Sprintf(
+			"reading from port %v failed: %v", port.Name, err))
+	}
+	return datum, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Check runs every .scm file found in the directories (or given explicitly)
+// and compares everything it prints with the adjacent .expected file. If the
+// evaluation fails, the error is appended to the output as "error: <text>"
+// line, so the failures may be expected too. Returns the exit code.
+func Check(paths []string, options Options) int {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := FindSourceFiles(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check: %v\n", err)
+		return 2
+	}
+	var passed, failed, skipped int
+	for _, file := range files {
+		expectedFile := strings.TrimSuffix(file, ".scm") + ".expected"
+		expected, err := os.ReadFile(expectedFile)
+		if os.IsNotExist(err) {
+			fmt.Printf("SKIP %v: no %v\n", file, expectedFile)
+			skipped++
+			continue
+		} else if err != nil {
+			fmt.Printf("FAIL %v: %v\n", file, err)
+			failed++
+			continue
+		}
+		actual := RunCaptured(file, options)
+		if actual == string(expected) {
+			fmt.Printf("PASS %v\n", file)
+			passed++
+			continue
+		}
+		fmt.Printf("FAIL %v\n", file)
+		for _, line := range DiffLines(SplitLines(string(expected)), SplitLines(actual)) {
+			fmt.Printf("    %v\n", line)
+		}
+		failed++
+	}
+	fmt.Printf("%v passed, %v failed, %v skipped\n", passed, failed, skipped)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// RunCaptured evaluates the file in a fresh interpreter and returns everything
+// printed to the current output port.
+func RunCaptured(file string, options Options) string {
+	interp := NewInterp()
+	if options.NoContracts {
+		interp.Table["*contracts*"] = Value{Type: ValBool, Bool: false}
+	}
+	port := NewStringPort()
+	interp.Output = port
+	if _, err := interp.EvalFile(file, options.FoldCase); err != nil {
+		output := port.Buffer.String()
+		if output != "" && !strings.HasSuffix(output, "\n") {
+			output += "\n"
+		}
+		return output + fmt.Sprintf("error: %v\n", err)
+	}
+	return port.Buffer.String()
+}
+
+// SplitLines splits the text into lines, the line break at the end of the text,
+// if any, does not produce an extra empty line.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// DiffLines returns a line diff of a and b: lines of a missing in b are
+// prefixed with "-", lines of b missing in a are prefixed with "+" and the
+// common lines are prefixed with " ".
+func DiffLines(a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			diff = append(diff, " "+a[i])
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			diff = append(diff, "-"+a[i])
+			i++
+		} else {
+			diff = append(diff, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, "+"+b[j])
+	}
+	return diff
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CompareNatural compares the strings like the file managers do: runs of
+// digits are compared as numbers, so "file2" goes before "file10", and
+// everything else is compared byte by byte, regardless of the locale. The
+// strings differing only in leading zeros are ordered byte by byte in the end,
+// so the order is total.
+func CompareNatural(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if !IsNumeric(a[i]) || !IsNumeric(b[j]) {
+			if a[i] != b[j] {
+				if a[i] < b[j] {
+					return -1
+				}
+				return 1
+			}
+			i++
+			j++
+			continue
+		}
+		startA, startB := i, j
+		for i < len(a) && IsNumeric(a[i]) {
+			i++
+		}
+		for j < len(b) && IsNumeric(b[j]) {
+			j++
+		}
+		numberA := strings.TrimLeft(a[startA:i], "0")
+		numberB := strings.TrimLeft(b[startB:j], "0")
+		// Numbers without leading zeros compare by length first
+		if len(numberA) != len(numberB) {
+			if len(numberA) < len(numberB) {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(numberA, numberB); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case i < len(a):
+		return 1
+	case j < len(b):
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+// stringCompareFn makes a variadic string comparison like `string<?`, which
+// tells whether every argument is less than the next one.
+func stringCompareFn(name string, compare func(a, b string) int) Value {
+	proc := func(arg Value, interp Interp) (Value, error) {
+		args, err := interp.RangeArgs(arg, name, 1, MaxArgs)
+		if err != nil {
+			return ValueNull(), err
+		}
+		for _, v := range args {
+			if v.Type != ValString {
+				return interp.NewEvalErrorOf(ErrWrongType, name, v, fmt.Sprintf(
+					"`%v` expects ValString, given: %v", name, v))
+			}
+		}
+		for i := 1; i < len(args); i++ {
+			if compare(args[i-1].StringData, args[i].StringData) >= 0 {
+				return Value{Type: ValBool, Bool: false}, nil
+			}
+		}
+		return Value{Type: ValBool, Bool: true}, nil
+	}
+	return Value{Type: ValProc, Proc: proc}
+}
+
+// sortFn handles `(sort sequence less?)` like Guile and Racket do. The sort
+// is stable, so the elements the predicate considers equal keep their order,
+// and the sequence is not modified: a new list or vector is returned.
+func sortFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "sort", 2, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	less, err := interp.ProcArg(args[1], "sort")
+	if err != nil {
+		return ValueNull(), err
+	}
+	sequence := args[0]
+	var items []Value
+	if sequence.Type == ValVector {
+		items = append(items, sequence.Vector.Items...)
+	} else if items, err = interp.RangeArgs(sequence, "sort", 0, MaxArgs); err != nil {
+		return ValueNull(), err
+	}
+	// The predicate may fail, then the rest of comparisons are skipped and
+	// the order does not matter
+	var failure error
+	sort.SliceStable(items, func(i, j int) bool {
+		if failure != nil {
+			return false
+		}
+		result, err := interp.Apply(less, NewList([]Value{items[i], items[j]}))
+		failure = err
+		return result.Type != ValBool || result.Bool
+	})
+	if failure != nil {
+		return ValueNull(), failure
+	}
+	if sequence.Type == ValVector {
+		return Value{Type: ValVector, Vector: &Vector{Items: items}}, nil
+	}
+	return NewList(items), nil
+}
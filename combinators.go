@@ -0,0 +1,74 @@
+package main
+
+import "fmt"
+
+// ProcArg ensures that the argument is a procedure.
+func (self Interp) ProcArg(v Value, name string) (Value, error) {
+	if v.Type != ValProc {
+		return self.NewEvalErrorOf(ErrWrongType, name, v, fmt.Sprintf(
+			"`%v` expects ValProc argument, given: %v", name, v))
+	}
+	return v, nil
+}
+
+func identityFn(arg Value, interp Interp) (Value, error) {
+	return interp.SingleArg(arg, "identity")
+}
+
+// constFn returns the procedure ignoring its arguments and returning the value.
+func constFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "const")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return Value{Type: ValProc, Symbol: "const", Proc: func(Value, Interp) (Value, error) {
+		return value, nil
+	}}, nil
+}
+
+// partialFn returns the procedure calling the given one with the given
+// arguments followed by its own arguments.
+func partialFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "partial", 1, MaxArgs)
+	if err != nil {
+		return ValueNull(), err
+	}
+	proc, err := interp.ProcArg(args[0], "partial")
+	if err != nil {
+		return ValueNull(), err
+	}
+	fixed := args[1:]
+	return Value{Type: ValProc, Symbol: "partial", Proc: func(arg Value, interp Interp) (Value, error) {
+		rest, err := interp.RangeArgs(arg, "partial", 0, MaxArgs)
+		if err != nil {
+			return ValueNull(), err
+		}
+		all := append(append([]Value{}, fixed...), rest...)
+		return interp.Apply(proc, NewList(all))
+	}}, nil
+}
+
+// composeFn returns the procedure applying the given ones from right to left,
+// the rightmost one gets all the arguments and every other gets the result of
+// the previous one. Composition of nothing is `identity`.
+func composeFn(arg Value, interp Interp) (Value, error) {
+	procs, err := interp.RangeArgs(arg, "compose", 0, MaxArgs)
+	if err != nil {
+		return ValueNull(), err
+	}
+	for _, proc := range procs {
+		if _, err := interp.ProcArg(proc, "compose"); err != nil {
+			return ValueNull(), err
+		}
+	}
+	if len(procs) == 0 {
+		return Value{Type: ValProc, Symbol: "identity", Proc: identityFn}, nil
+	}
+	return Value{Type: ValProc, Symbol: "compose", Proc: func(arg Value, interp Interp) (Value, error) {
+		result, err := interp.Apply(procs[len(procs)-1], arg)
+		for i := len(procs) - 2; i >= 0 && err == nil; i-- {
+			result, err = interp.Apply(procs[i], NewList([]Value{result}))
+		}
+		return result, err
+	}}, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Complex is an exact complex number, both parts are either ValNumber or
+// ValDecimal values.
+type Complex struct {
+	Real Value
+	Imag Value
+}
+
+// magnitudeScale is the count of digits after the decimal point of an inexact
+// magnitude, i.e. when it is not a perfect square.
+const magnitudeScale = 16
+
+func IsNumber(v Value) bool {
+	return IsReal(v) || v.Type == ValComplex
+}
+
+func IsZero(v Value) bool {
+	switch v.Type {
+	case ValNumber:
+		return v.Number == 0
+	case ValDecimal:
+		return v.Decimal.Unscaled.Sign() == 0
+	}
+	return false
+}
+
+// MakeComplex creates a complex number, which is a real number if imaginary
+// part is zero.
+func MakeComplex(real, imag Value) Value {
+	if IsZero(imag) {
+		return real
+	}
+	return Value{Type: ValComplex, Complex: &Complex{real, imag}}
+}
+
+func ToComplex(v Value) Complex {
+	if v.Type == ValComplex {
+		return *v.Complex
+	}
+	return Complex{v, Value{Type: ValNumber, Number: 0}}
+}
+
+// ParseComplex parses literals like "1+2i", "-3-i" and "+4i".
+func ParseComplex(repr string) (Complex, bool) {
+	if !strings.HasSuffix(repr, "i") {
+		return Complex{}, false
+	}
+	body := repr[:len(repr)-1]
+	split := strings.LastIndexAny(body, "+-")
+	if split < 0 {
+		return Complex{}, false
+	}
+	real := 0
+	if split > 0 {
+		number, err := strconv.Atoi(body[:split])
+		if err != nil {
+			return Complex{}, false
+		}
+		real = number
+	}
+	imagRepr := body[split:]
+	if len(imagRepr) == 1 {
+		// Like in "1+i"
+		imagRepr += "1"
+	}
+	imag, err := strconv.Atoi(imagRepr)
+	if err != nil {
+		return Complex{}, false
+	}
+	return Complex{Value{Type: ValNumber, Number: real}, Value{Type: ValNumber, Number: imag}}, true
+}
+
+func realString(v Value) string {
+	if v.Type == ValDecimal {
+		return v.Decimal.String()
+	}
+	return strconv.Itoa(v.Number)
+}
+
+func (c Complex) String() string {
+	var sb strings.Builder
+	if !IsZero(c.Real) {
+		sb.WriteString(realString(c.Real))
+	}
+	imag := realString(c.Imag)
+	if !strings.HasPrefix(imag, "-") {
+		imag = "+" + imag
+	}
+	if imag == "+1" || imag == "-1" {
+		imag = imag[:1]
+	}
+	sb.WriteString(imag)
+	sb.WriteString("i")
+	return sb.String()
+}
+
+func AddNumbers(a, b Value) Value {
+	if a.Type == ValComplex || b.Type == ValComplex {
+		x, y := ToComplex(a), ToComplex(b)
+		return MakeComplex(AddNumbers(x.Real, y.Real), AddNumbers(x.Imag, y.Imag))
+	}
+	if a.Type == ValDecimal || b.Type == ValDecimal {
+		return ValueDecimal(NumberToDecimal(a).Add(NumberToDecimal(b)))
+	}
+	return Value{Type: ValNumber, Number: a.Number + b.Number}
+}
+
+func SubNumbers(a, b Value) Value {
+	if a.Type == ValComplex || b.Type == ValComplex {
+		x, y := ToComplex(a), ToComplex(b)
+		return MakeComplex(SubNumbers(x.Real, y.Real), SubNumbers(x.Imag, y.Imag))
+	}
+	if a.Type == ValDecimal || b.Type == ValDecimal {
+		return ValueDecimal(NumberToDecimal(a).Sub(NumberToDecimal(b)))
+	}
+	return Value{Type: ValNumber, Number: a.Number - b.Number}
+}
+
+func MulNumbers(a, b Value) Value {
+	if a.Type == ValComplex || b.Type == ValComplex {
+		// (a + bi)(c + di) = (ac - bd) + (ad + bc)i
+		x, y := ToComplex(a), ToComplex(b)
+		return MakeComplex(
+			SubNumbers(MulNumbers(x.Real, y.Real), MulNumbers(x.Imag, y.Imag)),
+			AddNumbers(MulNumbers(x.Real, y.Imag), MulNumbers(x.Imag, y.Real)))
+	}
+	if a.Type == ValDecimal || b.Type == ValDecimal {
+		return ValueDecimal(NumberToDecimal(a).Mul(NumberToDecimal(b)))
+	}
+	return Value{Type: ValNumber, Number: a.Number * b.Number}
+}
+
+// SqrtDecimal returns square root of non-negative decimal. The result is exact
+// if the decimal is a perfect square, otherwise it is truncated to
+// magnitudeScale digits after the decimal point.
+func SqrtDecimal(d Decimal) Decimal {
+	// Scale is made even, so the root of unscaled value has exactly half of it
+	scale := 2 * magnitudeScale
+	if d.Scale > scale {
+		d = d.Round(scale, RoundDown)
+	}
+	n := d.rescale(scale)
+	root := new(big.Int).Sqrt(n)
+	result := Decimal{root, magnitudeScale}
+	if new(big.Int).Mul(root, root).Cmp(n) != 0 {
+		return result
+	}
+	// Exact root, trailing zeros are meaningless
+	for result.Scale > 0 {
+		q, r := new(big.Int).QuoRem(result.Unscaled, big.NewInt(10), new(big.Int))
+		if r.Sign() != 0 {
+			break
+		}
+		result = Decimal{q, result.Scale - 1}
+	}
+	return result
+}
+
+func (self Interp) SingleNumberArg(arg Value, name string) (Value, error) {
+	value, err := self.SingleArg(arg, name)
+	if err != nil {
+		return ValueNull(), err
+	}
+	if !IsNumber(value) {
+		return self.NewEvalErrorOf(ErrWrongType, name, value, fmt.Sprintf(
+			"`%v` expects number, given: %v", name, value))
+	}
+	return value, nil
+}
+
+func makeRectangularFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "make-rectangular", 2, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	for _, a := range args {
+		if !IsReal(a) {
+			return interp.NewEvalError(a, fmt.Sprintf(
+				"`make-rectangular` expects real number, given: %v", a))
+		}
+	}
+	return MakeComplex(args[0], args[1]), nil
+}
+
+func realPartFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleNumberArg(arg, "real-part")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return ToComplex(value).Real, nil
+}
+
+func imagPartFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleNumberArg(arg, "imag-part")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return ToComplex(value).Imag, nil
+}
+
+func magnitudeFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleNumberArg(arg, "magnitude")
+	if err != nil {
+		return ValueNull(), err
+	}
+	c := ToComplex(value)
+	if IsZero(c.Imag) {
+		if NumberToDecimal(c.Real).Unscaled.Sign() < 0 {
+			return SubNumbers(Value{Type: ValNumber, Number: 0}, c.Real), nil
+		}
+		return c.Real, nil
+	}
+	squares := AddNumbers(MulNumbers(c.Real, c.Real), MulNumbers(c.Imag, c.Imag))
+	root := SqrtDecimal(NumberToDecimal(squares))
+	if root.Scale == 0 && squares.Type == ValNumber && root.Unscaled.IsInt64() {
+		return Value{Type: ValNumber, Number: int(root.Unscaled.Int64())}, nil
+	}
+	return ValueDecimal(root), nil
+}
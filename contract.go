@@ -0,0 +1,126 @@
+package main
+
+import "fmt"
+
+// Contract is the `(-> domain... range)` contract of a procedure, every
+// element is a predicate procedure.
+type Contract struct {
+	Domain []Value
+	Range  Value
+	// Names are the expressions of the predicates, to be used in errors
+	Names []string
+}
+
+// ContractsEnabled tells whether `define/contract` wraps the procedures with
+// checks, which is controlled by `*contracts*` variable. Disabled contracts
+// cost nothing at run time, since the procedures are defined as is then.
+func (self *Interp) ContractsEnabled() bool {
+	value, ok := self.Lookup("*contracts*")
+	return !ok || value.Type != ValBool || value.Bool
+}
+
+// ParseContract evaluates the predicates of the contract.
+func (self *Interp) ParseContract(v Value) (Contract, error) {
+	if v.Type != ValPair || v.PairLeft.Type != ValSymbol || v.PairLeft.Symbol != "->" {
+		_, err := self.NewEvalError(v, fmt.Sprintf(
+			"`define/contract` expects contract (-> predicate... predicate), given: %v", v))
+		return Contract{}, err
+	}
+	items, err := self.RangeArgs(*v.PairRight, "->", 1, MaxArgs)
+	if err != nil {
+		return Contract{}, err
+	}
+	var contract Contract
+	for _, item := range items {
+		predicate, err := self.Eval(item)
+		if err != nil {
+			return Contract{}, err
+		}
+		if predicate.Type != ValProc {
+			_, err := self.NewEvalError(item, fmt.Sprintf(
+				"contract expects predicate procedures, given: %v", predicate))
+			return Contract{}, err
+		}
+		contract.Domain = append(contract.Domain, predicate)
+		contract.Names = append(contract.Names, Printer{}.Sprint(item))
+	}
+	last := len(contract.Domain) - 1
+	contract.Range = contract.Domain[last]
+	contract.Domain = contract.Domain[:last]
+	return contract, nil
+}
+
+// DefineContract defines the procedure like `define` does and wraps it with
+// the checks of arguments before the call and of the result after it.
+// Violations are reported at the call site.
+func (self *Interp) DefineContract(arg Value) (Value, error) {
+	args, err := self.RangeArgs(arg, "define/contract", 3, MaxArgs)
+	if err != nil {
+		return ValueNull(), err
+	}
+	signature := args[0]
+	if signature.Type != ValPair || signature.PairLeft.Type != ValSymbol {
+		return self.NewEvalError(signature, fmt.Sprintf(
+			"`define/contract` expects (name . params), given: %v", signature))
+	}
+	name := signature.PairLeft.Symbol
+	proc, err := self.Lambda(name, *signature.PairRight, *arg.PairRight.PairRight)
+	if err != nil {
+		return proc, err
+	}
+	if !self.ContractsEnabled() {
+		if err := self.Bind(name, proc, *signature.PairLeft); err != nil {
+			return ValueNull(), err
+		}
+		return ValueNull(), nil
+	}
+	contract, err := self.ParseContract(args[1])
+	if err != nil {
+		return ValueNull(), err
+	}
+	_, count, variadic := params(*signature.PairRight, nil)
+	if count != len(contract.Domain) && !(variadic && count < len(contract.Domain)) {
+		return self.NewEvalError(args[1], fmt.Sprintf(
+			"contract of `%v` has %v argument predicates, but the procedure takes %v arguments",
+			name, len(contract.Domain), count))
+	}
+	body := proc.Proc
+	proc.Proc = func(arg Value, interp Interp) (Value, error) {
+		i := 0
+		for list := arg; list.Type == ValPair && i < len(contract.Domain); list = *list.PairRight {
+			if ok, err := interp.Satisfies(contract.Domain[i], *list.PairLeft); err != nil {
+				return ValueNull(), err
+			} else if !ok {
+				return interp.NewEvalErrorOf(ErrContract, name, interp.Call, fmt.Sprintf(
+					"Contract violation: `%v` expects %v as argument %v, given: %v",
+					name, contract.Names[i], i+1, *list.PairLeft))
+			}
+			i++
+		}
+		result, err := body(arg, interp)
+		if err != nil {
+			return result, err
+		}
+		if ok, err := interp.Satisfies(contract.Range, result); err != nil {
+			return ValueNull(), err
+		} else if !ok {
+			return interp.NewEvalErrorOf(ErrContract, name, interp.Call, fmt.Sprintf(
+				"Contract violation: `%v` promised %v as result, produced: %v",
+				name, contract.Names[len(contract.Names)-1], result))
+		}
+		return result, nil
+	}
+	if err := self.Bind(name, proc, *signature.PairLeft); err != nil {
+		return ValueNull(), err
+	}
+	return ValueNull(), nil
+}
+
+// Satisfies applies the predicate to the value, anything but #f satisfies it.
+func (self Interp) Satisfies(predicate Value, value Value) (bool, error) {
+	result, err := self.Apply(predicate, NewList([]Value{value}))
+	if err != nil {
+		return false, err
+	}
+	return result.Type != ValBool || result.Bool, nil
+}
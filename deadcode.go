@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// parseInterspersed parses the flags of a subcommand given before, after or
+// between the positional arguments and returns the positional ones.
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// callGraph connects the top level names with the names their definitions
+// refer to. The forms other than definitions and the initializers of the
+// definitions of values are the roots, since they are what the program does
+// when it runs.
+type callGraph struct {
+	edges map[string][]string
+	// definitions are the definition sites in the order of the source
+	definitions []Value
+	roots       []string
+}
+
+func (self *callGraph) form(form Value) {
+	if form.Type == ValPair && form.PairLeft.Type == ValSymbol {
+		switch head := form.PairLeft.Symbol; {
+		case head == "begin" || head == "begin-for-syntax":
+			for list := *form.PairRight; list.Type == ValPair; list = *list.PairRight {
+				self.form(*list.PairLeft)
+			}
+			return
+		case definers[head]:
+			if name := listName(form); name.Type == ValSymbol {
+				self.definition(name.Symbol, form)
+				return
+			}
+		}
+	}
+	for _, reference := range TopLevelReferences([]Value{form}) {
+		if reference.Kind == RefUse {
+			self.roots = append(self.roots, reference.Symbol.Symbol)
+		}
+	}
+}
+
+// initialized tells whether the definition evaluates an expression when the
+// program is loaded, like `(define x (helper))` does, as opposed to defining
+// a procedure, whose body is evaluated only when it is called.
+func initialized(form Value) bool {
+	if form.PairLeft.Symbol != "define" || form.PairRight.Type != ValPair {
+		return false
+	}
+	target, rest := *form.PairRight.PairLeft, *form.PairRight.PairRight
+	if target.Type != ValSymbol || rest.Type != ValPair {
+		return false
+	}
+	value := *rest.PairLeft
+	return value.Type != ValPair || value.PairLeft.Type != ValSymbol || value.PairLeft.Symbol != "lambda"
+}
+
+func (self *callGraph) definition(name string, form Value) {
+	initializer := initialized(form)
+	for _, reference := range TopLevelReferences([]Value{form}) {
+		switch reference.Kind {
+		case RefUse:
+			if initializer {
+				self.roots = append(self.roots, reference.Symbol.Symbol)
+				continue
+			}
+			self.edges[name] = append(self.edges[name], reference.Symbol.Symbol)
+		case RefDefinition:
+			if reference.Symbol.Symbol == name {
+				self.definitions = append(self.definitions, reference.Symbol)
+			}
+		}
+	}
+}
+
+// reachable returns the names reachable from the roots.
+func (self *callGraph) reachable() map[string]bool {
+	seen := map[string]bool{}
+	queue := append([]string{}, self.roots...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		queue = append(queue, self.edges[name]...)
+	}
+	return seen
+}
+
+// Deadcode reports the top level definitions of the program that are never
+// used by the top level forms, directly or through other definitions.
+// Definitions of libraries are used by other programs, so their public names
+// are given with --entry. The included files are followed. Returns the exit
+// code, which is 1 if there are unused definitions.
+func Deadcode(args []string, options Options) int {
+	flags := flag.NewFlagSet("deadcode", flag.ExitOnError)
+	var entries []string
+	flags.Func("entry", "the `name` is used from outside of the program, may be repeated",
+		func(name string) error {
+			entries = append(entries, name)
+			return nil
+		})
+	paths := parseInterspersed(flags, args)
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	program, err := LoadProgram(paths, options.FoldCase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "deadcode: %v\n", err)
+		return 2
+	}
+	graph := callGraph{edges: map[string][]string{}, roots: entries}
+	for _, file := range program {
+		for _, expression := range file.Expressions {
+			graph.form(expression)
+		}
+	}
+	used := graph.reachable()
+	code := 0
+	for _, definition := range graph.definitions {
+		if used[definition.Symbol] {
+			continue
+		}
+		fmt.Println(NewError(definition.Token.Source, definition.Token.Offset, fmt.Sprintf(
+			"`%v` is defined, but never used", definition.Symbol)).Error())
+		code = 1
+	}
+	return code
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Decimal is an exact decimal number: Unscaled * 10^(-Scale). Operations never
+// modify the operands, a new Decimal is returned instead.
+type Decimal struct {
+	Unscaled *big.Int
+	Scale    int
+}
+
+type RoundingMode int
+
+const (
+	RoundHalfEven RoundingMode = iota
+	RoundHalfUp
+	RoundDown
+	RoundUp
+	RoundFloor
+	RoundCeiling
+)
+
+var roundingModeNames = map[string]RoundingMode{
+	"half-even": RoundHalfEven,
+	"half-up":   RoundHalfUp,
+	"down":      RoundDown,
+	"up":        RoundUp,
+	"floor":     RoundFloor,
+	"ceiling":   RoundCeiling,
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func DecimalFromInt(n int) Decimal {
+	return Decimal{big.NewInt(int64(n)), 0}
+}
+
+// ParseDecimal parses representation like "-12.340", without the "#d" prefix.
+// Trailing zeros are preserved in the scale, so "1.50" has scale of 2.
+func ParseDecimal(repr string) (Decimal, bool) {
+	digits := repr
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		digits = digits[1:]
+	}
+	intPart, fracPart := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		intPart, fracPart = digits[:i], digits[i+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return Decimal{}, false
+	}
+	for _, c := range []byte(intPart + fracPart) {
+		if !IsNumeric(c) {
+			return Decimal{}, false
+		}
+	}
+	unscaled, ok := new(big.Int).SetString("0"+intPart+fracPart, 10)
+	if !ok {
+		return Decimal{}, false
+	}
+	if strings.HasPrefix(repr, "-") {
+		unscaled.Neg(unscaled)
+	}
+	return Decimal{unscaled, len(fracPart)}, true
+}
+
+// rescale returns unscaled value of the decimal with the scale increased to
+// the given one.
+func (d Decimal) rescale(scale int) *big.Int {
+	return new(big.Int).Mul(d.Unscaled, pow10(scale-d.Scale))
+}
+
+func maxScale(a, b Decimal) int {
+	if a.Scale > b.Scale {
+		return a.Scale
+	}
+	return b.Scale
+}
+
+func (d Decimal) Add(o Decimal) Decimal {
+	scale := maxScale(d, o)
+	return Decimal{new(big.Int).Add(d.rescale(scale), o.rescale(scale)), scale}
+}
+
+func (d Decimal) Sub(o Decimal) Decimal {
+	scale := maxScale(d, o)
+	return Decimal{new(big.Int).Sub(d.rescale(scale), o.rescale(scale)), scale}
+}
+
+func (d Decimal) Mul(o Decimal) Decimal {
+	return Decimal{new(big.Int).Mul(d.Unscaled, o.Unscaled), d.Scale + o.Scale}
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{new(big.Int).Neg(d.Unscaled), d.Scale}
+}
+
+func (d Decimal) Cmp(o Decimal) int {
+	scale := maxScale(d, o)
+	return d.rescale(scale).Cmp(o.rescale(scale))
+}
+
+// roundQuo divides n by positive d and rounds the quotient to an integer
+// according to the mode.
+func roundQuo(n, d *big.Int, mode RoundingMode) *big.Int {
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+	if r.Sign() == 0 {
+		return q
+	}
+	sign := int64(n.Sign())
+	// Doubled remainder is compared against divisor to tell whether the
+	// remainder is below, exactly at or above the half.
+	half := new(big.Int).Abs(r)
+	half.Lsh(half, 1)
+	halfCmp := half.Cmp(d)
+	var increment bool
+	switch mode {
+	case RoundHalfEven:
+		increment = halfCmp > 0 || (halfCmp == 0 && q.Bit(0) == 1)
+	case RoundHalfUp:
+		increment = halfCmp >= 0
+	case RoundDown:
+		increment = false
+	case RoundUp:
+		increment = true
+	case RoundFloor:
+		increment = sign < 0
+	case RoundCeiling:
+		increment = sign > 0
+	}
+	if increment {
+		q.Add(q, big.NewInt(sign))
+	}
+	return q
+}
+
+// Round returns the decimal with the given scale, i.e. rounded to the given
+// number of digits after the decimal point.
+func (d Decimal) Round(scale int, mode RoundingMode) Decimal {
+	if scale >= d.Scale {
+		return Decimal{d.rescale(scale), scale}
+	}
+	return Decimal{roundQuo(d.Unscaled, pow10(d.Scale-scale), mode), scale}
+}
+
+// Quo returns d/o rounded to the given scale. Divisor must not be zero.
+func (d Decimal) Quo(o Decimal, scale int, mode RoundingMode) Decimal {
+	// d/o = (d.U * 10^o.S) / (o.U * 10^d.S), and the result is multiplied by
+	// 10^scale to obtain the unscaled value.
+	n := new(big.Int).Mul(d.Unscaled, pow10(o.Scale+scale))
+	m := new(big.Int).Mul(o.Unscaled, pow10(d.Scale))
+	if m.Sign() < 0 {
+		n.Neg(n)
+		m.Neg(m)
+	}
+	return Decimal{roundQuo(n, m, mode), scale}
+}
+
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.Unscaled).String()
+	if len(digits) <= d.Scale {
+		digits = strings.Repeat("0", d.Scale-len(digits)+1) + digits
+	}
+	var sign string
+	if d.Unscaled.Sign() < 0 {
+		sign = "-"
+	}
+	if d.Scale == 0 {
+		return sign + digits
+	}
+	point := len(digits) - d.Scale
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+func ValueDecimal(d Decimal) Value {
+	return Value{Type: ValDecimal, Decimal: d}
+}
+
+// NumberToDecimal converts ValNumber or ValDecimal value into Decimal.
+func NumberToDecimal(v Value) Decimal {
+	if v.Type == ValNumber {
+		return DecimalFromInt(v.Number)
+	}
+	v.assertType(ValDecimal)
+	return v.Decimal
+}
+
+func IsReal(v Value) bool {
+	return v.Type == ValNumber || v.Type == ValDecimal
+}
+
+func decimalPredicateFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "decimal?")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return Value{Type: ValBool, Bool: value.Type == ValDecimal}, nil
+}
+
+// roundingArgs parses `number scale [mode]` part of the arguments of
+// `decimal-round` and `decimal/`.
+func (self Interp) roundingArgs(args []Value, name string) (int, RoundingMode, error) {
+	if args[0].Type != ValNumber || args[0].Number < 0 {
+		_, err := self.NewEvalError(args[0], fmt.Sprintf(
+			"`%v` expects non-negative scale, given: %v", name, args[0]))
+		return 0, RoundHalfEven, err
+	}
+	if len(args) == 1 {
+		return args[0].Number, RoundHalfEven, nil
+	}
+	mode, ok := roundingModeNames[args[1].Symbol]
+	if args[1].Type != ValSymbol || !ok {
+		_, err := self.NewEvalError(args[1], fmt.Sprintf(
+			"`%v` expects rounding mode (half-even, half-up, down, up, floor or ceiling), given: %v",
+			name, args[1]))
+		return 0, RoundHalfEven, err
+	}
+	return args[0].Number, mode, nil
+}
+
+func decimalRoundFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "decimal-round", 2, 3)
+	if err != nil {
+		return ValueNull(), err
+	}
+	if !IsReal(args[0]) {
+		return interp.NewEvalError(args[0], fmt.Sprintf(
+			"`decimal-round` expects number, given: %v", args[0]))
+	}
+	scale, mode, err := interp.roundingArgs(args[1:], "decimal-round")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return ValueDecimal(NumberToDecimal(args[0]).Round(scale, mode)), nil
+}
+
+func decimalQuoFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "decimal/", 3, 4)
+	if err != nil {
+		return ValueNull(), err
+	}
+	for _, a := range args[:2] {
+		if !IsReal(a) {
+			return interp.NewEvalError(a, fmt.Sprintf(
+				"`decimal/` expects number, given: %v", a))
+		}
+	}
+	scale, mode, err := interp.roundingArgs(args[2:], "decimal/")
+	if err != nil {
+		return ValueNull(), err
+	}
+	divisor := NumberToDecimal(args[1])
+	if divisor.Unscaled.Sign() == 0 {
+		return interp.NewEvalError(args[1], "`decimal/` division by zero")
+	}
+	return ValueDecimal(NumberToDecimal(args[0]).Quo(divisor, scale, mode)), nil
+}
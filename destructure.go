@@ -0,0 +1,135 @@
+package main
+
+import "fmt"
+
+// patternNames returns the names bound by the pattern of
+// `destructuring-bind`, see DestructuringBind. `_` binds nothing.
+func patternNames(pattern Value, names []Value) []Value {
+	switch pattern.Type {
+	case ValSymbol:
+		if pattern.Symbol != "_" {
+			names = append(names, pattern)
+		}
+	case ValPair:
+		names = patternNames(*pattern.PairLeft, names)
+		names = patternNames(*pattern.PairRight, names)
+	case ValVector:
+		for _, item := range pattern.Vector.Items {
+			names = patternNames(item, names)
+		}
+	}
+	return names
+}
+
+// checkPattern reports the parts of the pattern that are neither symbols,
+// lists nor vectors, and the names bound twice.
+func (self *Interp) checkPattern(pattern Value) error {
+	seen := map[string]bool{}
+	var check func(Value) error
+	check = func(v Value) error {
+		switch v.Type {
+		case ValNull:
+			return nil
+		case ValSymbol:
+			if v.Symbol != "_" && seen[v.Symbol] {
+				_, err := self.NewEvalError(v, fmt.Sprintf(
+					"`destructuring-bind` pattern binds `%v` twice", v.Symbol))
+				return err
+			}
+			seen[v.Symbol] = true
+			return nil
+		case ValPair:
+			if err := check(*v.PairLeft); err != nil {
+				return err
+			}
+			return check(*v.PairRight)
+		case ValVector:
+			for _, item := range v.Vector.Items {
+				if err := check(item); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		_, err := self.NewEvalError(v, fmt.Sprintf(
+			"`destructuring-bind` pattern expects symbols, lists and vectors, given: %v", v))
+		return err
+	}
+	return check(pattern)
+}
+
+// match binds the names of the pattern to the parts of the value. The error
+// is located at the part of the pattern that does not match, or at the site,
+// which is the innermost part having location, for the tails of lists.
+func (self *Interp) match(pattern Value, value Value, site Value, table map[string]Value) error {
+	if pattern.Token.Source != nil {
+		site = pattern
+	}
+	mismatch := func() error {
+		_, err := self.NewEvalErrorOf(ErrWrongType, "destructuring-bind", site, fmt.Sprintf(
+			"`destructuring-bind` pattern %v does not match %v",
+			Printer{}.Sprint(pattern), Printer{Level: 3, Length: 8}.Sprint(value)))
+		return err
+	}
+	switch pattern.Type {
+	case ValSymbol:
+		if pattern.Symbol != "_" {
+			table[pattern.Symbol] = value
+		}
+		return nil
+	case ValNull:
+		if value.Type != ValNull {
+			return mismatch()
+		}
+		return nil
+	case ValPair:
+		if value.Type != ValPair {
+			return mismatch()
+		}
+		if err := self.match(*pattern.PairLeft, *value.PairLeft, site, table); err != nil {
+			return err
+		}
+		return self.match(*pattern.PairRight, *value.PairRight, site, table)
+	case ValVector:
+		if value.Type != ValVector || len(value.Vector.Items) != len(pattern.Vector.Items) {
+			return mismatch()
+		}
+		for i, item := range pattern.Vector.Items {
+			if err := self.match(item, value.Vector.Items[i], site, table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return mismatch()
+}
+
+// DestructuringBind handles `(destructuring-bind pattern expression body...)`.
+// The pattern is a tree of symbols shaped like the value of the expression:
+// lists, dotted lists and vectors in the pattern match the same structure of
+// the value, and the symbols are bound to what they match in a new scope the
+// body is evaluated in. `_` matches anything without binding it.
+func (self *Interp) DestructuringBind(arg Value) (Value, error) {
+	args, err := self.RangeArgs(arg, "destructuring-bind", 3, MaxArgs)
+	if err != nil {
+		return ValueNull(), err
+	}
+	pattern := args[0]
+	if err := self.checkPattern(pattern); err != nil {
+		return ValueNull(), err
+	}
+	value, err := self.Eval(args[1])
+	if err != nil {
+		return value, err
+	}
+	env := &Env{Table: map[string]Value{}, Parent: self.Env}
+	if err := self.match(pattern, value, pattern, env.Table); err != nil {
+		return ValueNull(), err
+	}
+	for _, name := range patternNames(pattern, nil) {
+		self.warnShadow(name.Symbol, name)
+	}
+	inner := *self
+	inner.Env = env
+	return inner.Begin(*arg.PairRight.PairRight)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// diffPrinter abbreviates the differing values, the locations tell where to
+// look at the whole of them.
+var diffPrinter = Printer{Level: 4, Length: 12}
+
+// structuralDiff compares the values ignoring formatting and comments, which
+// the parser drops anyway. Every difference is a line of the value removed
+// from a prefixed with "-" and a line of the value added in b prefixed with
+// "+", both located in their files.
+type structuralDiff struct {
+	lines []string
+}
+
+func (self *structuralDiff) removed(v Value) {
+	self.lines = append(self.lines,
+		"- "+NewError(v.Token.Source, v.Token.Offset, diffPrinter.Sprint(v)).Error())
+}
+
+func (self *structuralDiff) added(v Value) {
+	self.lines = append(self.lines,
+		"+ "+NewError(v.Token.Source, v.Token.Offset, diffPrinter.Sprint(v)).Error())
+}
+
+// elements returns the elements of a proper list or a vector, the last
+// result is false for anything else, which is compared as a whole.
+func elements(v Value) ([]Value, bool) {
+	if v.Type == ValVector {
+		return v.Vector.Items, true
+	}
+	var items []Value
+	for ; v.Type == ValPair; v = *v.PairRight {
+		items = append(items, *v.PairLeft)
+	}
+	return items, v.Type == ValNull
+}
+
+// values reports the difference of two values. Lists and vectors are compared
+// element by element, which narrows the difference down to the elements that
+// actually differ.
+func (self *structuralDiff) values(a, b Value) {
+	if Equal(a, b) {
+		return
+	}
+	itemsA, okA := elements(a)
+	itemsB, okB := elements(b)
+	if !okA || !okB || a.Type != b.Type {
+		self.removed(a)
+		self.added(b)
+		return
+	}
+	self.sequences(itemsA, itemsB)
+}
+
+// similar tells whether the values are worth comparing in detail rather than
+// reporting one as removed and the other as added. Atoms are similar to
+// atoms, lists are similar if they have the same head. Definitions must
+// define the same name too, which is the second element or its head, like in
+// (define (name ...) ...).
+func similar(a, b Value) bool {
+	if a.Type != b.Type {
+		return a.Type != ValPair && b.Type != ValPair && a.Type != ValVector && b.Type != ValVector
+	}
+	if a.Type != ValPair {
+		return true
+	}
+	if !Equal(*a.PairLeft, *b.PairLeft) {
+		return false
+	}
+	if a.PairLeft.Type != ValSymbol || !definers[a.PairLeft.Symbol] {
+		return true
+	}
+	return Equal(listName(a), listName(b))
+}
+
+func listName(v Value) Value {
+	if v.PairRight.Type != ValPair {
+		return ValueNull()
+	}
+	name := *v.PairRight.PairLeft
+	if name.Type == ValPair {
+		return *name.PairLeft
+	}
+	return name
+}
+
+// sequences aligns the sequences by their longest common subsequence of equal
+// values. Between the common values the remaining ones are compared with the
+// similar ones in order, the rest are removed or added.
+func (self *structuralDiff) sequences(a, b []Value) {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if Equal(a[i], b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var runA, runB []Value
+	flush := func() {
+		next := 0
+		for _, itemA := range runA {
+			m := next
+			for m < len(runB) && !similar(itemA, runB[m]) {
+				m++
+			}
+			if m == len(runB) {
+				self.removed(itemA)
+				continue
+			}
+			for ; next < m; next++ {
+				self.added(runB[next])
+			}
+			self.values(itemA, runB[m])
+			next = m + 1
+		}
+		for ; next < len(runB); next++ {
+			self.added(runB[next])
+		}
+		runA, runB = runA[:0], runB[:0]
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if Equal(a[i], b[j]) {
+			flush()
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			runA = append(runA, a[i])
+			i++
+		} else {
+			runB = append(runB, b[j])
+			j++
+		}
+	}
+	runA = append(runA, a[i:]...)
+	runB = append(runB, b[j:]...)
+	flush()
+}
+
+// Diff prints the structural differences of the two files, see
+// structuralDiff. Returns the exit code like diff(1) does: 0 if the files are
+// the same, 1 if they differ and 2 on errors.
+func Diff(files []string, options Options) int {
+	if len(files) != 2 {
+		fmt.Fprintf(os.Stderr, "diff: two files expected\n")
+		return 2
+	}
+	var programs [2][]Value
+	for i, file := range files {
+		_, expressions, err := ParseFile(file, options.FoldCase)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "diff: %v\n", err)
+			return 2
+		}
+		programs[i] = expressions
+	}
+	var diff structuralDiff
+	diff.sequences(programs[0], programs[1])
+	for _, line := range diff.lines {
+		fmt.Println(line)
+	}
+	if len(diff.lines) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dotWriter emits Graphviz DOT of the cons-cell graph. Pairs and vectors are
+// nodes, shared ones are emitted once, so structure sharing and cycles are
+// visible as several edges pointing to the same node.
+type dotWriter struct {
+	sb *strings.Builder
+	// names are the names of the nodes emitted, keyed by the identity of the
+	// pair or the vector, see identity
+	names map[interface{}]string
+}
+
+func dotEscape(s string) string {
+	var sb strings.Builder
+	for _, c := range []byte(s) {
+		if strings.IndexByte("\"\\|{}<> ", c) >= 0 {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// field returns the label of the record field for the value and emits the edge
+// from the field if the value is a node itself.
+func (self *dotWriter) field(from string, v Value) string {
+	switch v.Type {
+	case ValPair, ValVector, ValArray:
+		self.sb.WriteString(fmt.Sprintf("  %v -> %v;\n", from, self.node(v)))
+		return ""
+	case ValNull:
+		return "/"
+	}
+	return dotEscape(Printer{}.Sprint(v))
+}
+
+// node emits the node for the pair or vector if not emitted yet, and returns
+// its name.
+func (self *dotWriter) node(v Value) string {
+	if name, ok := self.names[identity(v)]; ok {
+		return name
+	}
+	name := fmt.Sprintf("n%v", len(self.names))
+	self.names[identity(v)] = name
+	var fields []Value
+	if v.Type == ValPair {
+		fields = []Value{*v.PairLeft, *v.PairRight}
+	} else {
+		fields = v.Vector.Items
+	}
+	labels := make([]string, len(fields))
+	for i, field := range fields {
+		labels[i] = self.field(fmt.Sprintf("%v:f%v", name, i), field)
+	}
+	var sb strings.Builder
+	for i, label := range labels {
+		if i != 0 {
+			sb.WriteString("|")
+		}
+		sb.WriteString(fmt.Sprintf("<f%v> %v", i, label))
+	}
+	self.sb.WriteString(fmt.Sprintf("  %v [label=\"%v\"];\n", name, sb.String()))
+	return name
+}
+
+// WriteDot returns Graphviz DOT representation of the value's cons-cell graph.
+func WriteDot(v Value) string {
+	var sb strings.Builder
+	writer := dotWriter{&sb, map[interface{}]string{}}
+	sb.WriteString("digraph value {\n")
+	sb.WriteString("  node [shape=record];\n")
+	switch v.Type {
+	case ValPair, ValVector, ValArray:
+		writer.node(v)
+	default:
+		sb.WriteString(fmt.Sprintf("  n0 [shape=plaintext, label=\"%v\"];\n",
+			dotEscape(Printer{}.Sprint(v))))
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func writeDotFn(arg Value, interp Interp) (Value, error) {
+	value, port, err := interp.printArgs(arg, "write-dot")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return interp.WritePort(port, WriteDot(value))
+}
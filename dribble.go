@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Dribble duplicates the input and the output of the REPL session into a file
+// while the file is open, see `dribble` procedure. The input is taken from the
+// source of the parser when it is evaluated, not when it is read, since the
+// REPL reads ahead of the evaluation.
+type Dribble struct {
+	// Source is the input consumed by the parser
+	Source *strings.Builder
+	Output io.Writer
+	file   *os.File
+	// recorded is the length of the source taken already
+	recorded int
+}
+
+// Record takes the source up to the offset as the input evaluated next, it is
+// written into the file if the file is open. The blanks consumed after the
+// offset are taken up to the end of the line, so that the output of the form
+// starts on the line of its own.
+func (self *Dribble) Record(offset int) {
+	source := self.Source.String()
+	for offset < len(source) && (source[offset] == ' ' || source[offset] == '\t' || source[offset] == '\r') {
+		offset++
+	}
+	if offset < len(source) && source[offset] == '\n' {
+		offset++
+	}
+	if offset <= self.recorded {
+		return
+	}
+	if self.file != nil {
+		self.file.WriteString(source[self.recorded:offset])
+	}
+	self.recorded = offset
+}
+
+func (self *Dribble) Write(p []byte) (int, error) {
+	if self.file != nil {
+		self.file.Write(p)
+	}
+	return self.Output.Write(p)
+}
+
+// Open starts duplicating the session into the file, the file is truncated.
+func (self *Dribble) Open(path string) error {
+	if err := self.Close(); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	self.file = file
+	return nil
+}
+
+func (self *Dribble) Close() error {
+	if self.file == nil {
+		return nil
+	}
+	err := self.file.Close()
+	self.file = nil
+	return err
+}
+
+func dribbleFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "dribble", 0, 1)
+	if err != nil {
+		return ValueNull(), err
+	}
+	if interp.Dribble == nil {
+		return interp.NewEvalError(arg, "`dribble` is available in REPL only")
+	}
+	if len(args) == 0 {
+		err = interp.Dribble.Close()
+	} else if args[0].Type != ValString {
+		return interp.NewEvalErrorOf(ErrWrongType, "dribble", args[0], fmt.Sprintf(
+			"`dribble` expects ValString file name, given: %v", args[0]))
+	} else {
+		err = interp.Dribble.Open(args[0].StringData)
+	}
+	if err != nil {
+		return interp.NewEvalError(arg, fmt.Sprintf("`dribble` failed: %v", err))
+	}
+	return ValueNull(), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// definers are the forms, which source is recorded for the defined name, see
+// RecordDefinition.
+var definers = map[string]bool{
+	"define":          true,
+	"define/contract": true,
+	"define-memoized": true,
+	"define-macro":    true,
+}
+
+// RecordDefinition remembers the source text of the top level definition, so
+// it can be edited with `,edit` later. It is recorded even if the definition
+// fails, since the failed one is the most likely to be edited.
+func (self *Interp) RecordDefinition(form Value) {
+	if self.Env != nil || self.Definitions == nil {
+		return
+	}
+	target := *form.PairRight
+	if target.Type != ValPair {
+		return
+	}
+	name := *target.PairLeft
+	if name.Type == ValPair {
+		name = *name.PairLeft
+	}
+	if name.Type != ValSymbol {
+		return
+	}
+	source := self.SourceOf(form)
+	start, end := Span(form)
+	text := source.Text.String()
+	if form.Token.Source != source || start < 0 || end > len(text) {
+		return
+	}
+	self.Definitions[name.Symbol] = text[start:end]
+}
+
+// editCommand opens the recorded definition in $EDITOR and evaluates the
+// edited file, if it has been changed.
+func editCommand(args string, interp Interp, output io.Writer) error {
+	if args == "" {
+		return fmt.Errorf("name expected")
+	}
+	definition, ok := interp.Definitions[args]
+	if !ok {
+		return fmt.Errorf("no recorded definition of `%v`", args)
+	}
+	file, err := os.CreateTemp("", "golisp-edit-*.scm")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(definition + "\n")
+	file.Close()
+	if err != nil {
+		return err
+	}
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], file.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor failed: %v", err)
+	}
+	edited, err := os.ReadFile(file.Name())
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(edited)) == strings.TrimSpace(definition) {
+		fmt.Fprintf(output, "`%v` is unchanged\n", args)
+		return nil
+	}
+	if _, err := interp.EvalFile(file.Name(), false); err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "`%v` is re-evaluated\n", args)
+	return nil
+}
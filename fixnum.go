@@ -0,0 +1,88 @@
+package main
+
+// FixnumOp marks the builtin procedures that Eval applies by itself when all
+// the arguments are ValNumber, which saves building the list of arguments and
+// the call of Proc.
+type FixnumOp int
+
+const (
+	FixnumNone FixnumOp = iota
+	FixnumPlus
+	FixnumMinus
+	FixnumLess
+	FixnumEqual
+)
+
+var fixnumOps = map[string]FixnumOp{
+	"+": FixnumPlus,
+	"-": FixnumMinus,
+	"<": FixnumLess,
+	"=": FixnumEqual,
+}
+
+// maxFixnumArgs is the count of arguments the fast path keeps on stack, calls
+// with more arguments take the generic path.
+const maxFixnumArgs = 4
+
+// EvalFixnum evaluates the call expression of the fixnum procedure, the
+// argument list must be proper. The list of evaluated arguments is built only
+// when some of them are not ValNumber and Proc has to be called after all, with
+// Call set to the expression like eval does. The second result is false if the
+// call is not suitable for the fast path, nothing is evaluated then.
+func (self *Interp) EvalFixnum(proc Value, expression Value) (Value, bool, error) {
+	list := *expression.PairRight
+	var args [maxFixnumArgs]Value
+	var count int
+	for rest := list; rest.Type == ValPair; rest = *rest.PairRight {
+		if count == maxFixnumArgs {
+			return ValueNull(), false, nil
+		}
+		count++
+	}
+	if count == 0 {
+		return ValueNull(), false, nil
+	}
+	fixnums := true
+	for i := 0; i < count; i++ {
+		value, err := self.Eval(*list.PairLeft)
+		if err != nil {
+			return ValueNull(), true, err
+		}
+		args[i] = value
+		fixnums = fixnums && value.Type == ValNumber
+		list = *list.PairRight
+	}
+	if !fixnums {
+		caller := *self
+		caller.Call = expression
+		result, err := proc.Proc(NewList(args[:count]), caller)
+		return result, true, err
+	}
+	return applyFixnum(proc.Fixnum, args[:count]), true, nil
+}
+
+func applyFixnum(op FixnumOp, args []Value) Value {
+	acc := args[0].Number
+	switch op {
+	case FixnumPlus:
+		for _, v := range args[1:] {
+			acc += v.Number
+		}
+	case FixnumMinus:
+		if len(args) == 1 {
+			return Value{Type: ValNumber, Number: -acc}
+		}
+		for _, v := range args[1:] {
+			acc -= v.Number
+		}
+	case FixnumLess, FixnumEqual:
+		for _, v := range args[1:] {
+			if (op == FixnumLess && acc >= v.Number) || (op == FixnumEqual && acc != v.Number) {
+				return Value{Type: ValBool, Bool: false}
+			}
+			acc = v.Number
+		}
+		return Value{Type: ValBool, Bool: true}
+	}
+	return Value{Type: ValNumber, Number: acc}
+}
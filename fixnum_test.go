@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// arithmeticSource is the arithmetic-heavy procedure the benchmarks call, the
+// language has no loops, so the benchmark loop is the loop.
+const arithmeticSource = `
+(define (step x y)
+  (list (+ (- x 1) (+ x y) (- (+ x 3) (- y 2)))
+        (- (+ (+ x x) (+ y y)) (- x y) 7)
+        (< (+ x 1) (- y 1) (+ x y))
+        (= (- (+ x y) y) x)))
+(step 10 20)`
+
+// benchmarkArithmetic evaluates the call of arithmeticSource, through
+// EvalFixnum if fixnum is set, and through the generic Proc path otherwise.
+func benchmarkArithmetic(b *testing.B, fixnum bool) {
+	var parser Pars
+	parser.Lex.Name = "benchmark"
+	expressions, err := parser.ParseAll(strings.NewReader(arithmeticSource))
+	if err != nil {
+		b.Fatal(err)
+	}
+	interp := NewInterp()
+	if !fixnum {
+		for name := range fixnumOps {
+			value := interp.Table[name]
+			value.Fixnum = FixnumNone
+			interp.Table[name] = value
+		}
+	}
+	if _, err := interp.Eval(expressions[0]); err != nil {
+		b.Fatal(err)
+	}
+	call := expressions[1]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := interp.Eval(call); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkArithmeticFixnum(b *testing.B) {
+	benchmarkArithmetic(b, true)
+}
+
+func BenchmarkArithmeticGeneric(b *testing.B) {
+	benchmarkArithmetic(b, false)
+}
+
+// TestFixnumErrorLocation checks that the errors of the calls falling back
+// from the fast path to Proc point at the call, like the generic path does.
+func TestFixnumErrorLocation(t *testing.T) {
+	const source = "(define (f x)\n  (list (- x 1)\n        (+ 1 x)))\n(f \"a\")"
+	for _, fixnum := range []bool{true, false} {
+		var parser Pars
+		parser.Lex.Name = "test"
+		expressions, err := parser.ParseAll(strings.NewReader(source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		interp := NewInterp()
+		interp.Register(&parser.Lex)
+		if !fixnum {
+			for name := range fixnumOps {
+				value := interp.Table[name]
+				value.Fixnum = FixnumNone
+				interp.Table[name] = value
+			}
+		}
+		if _, err := interp.Eval(expressions[0]); err != nil {
+			t.Fatal(err)
+		}
+		_, err = interp.Eval(expressions[1])
+		e, ok := err.(Error)
+		if !ok || e.LineNumber != 2 || e.OffsetInLine != 9 || e.Subject != "-" {
+			t.Errorf("fixnum %v: expected `-` error at 2:9, got %v", fixnum, err)
+		}
+	}
+}
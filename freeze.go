@@ -0,0 +1,121 @@
+package main
+
+import "fmt"
+
+// Freeze makes the pair or the vector immutable, so `set-car!`, `set-cdr!`,
+// `vector-set!` and the like fail on it. Deep freezing freezes everything
+// reachable from the value too. Freezing anything else does nothing, since
+// the other values can't be modified anyway. Vectors are marked in their
+// shared header, and pairs, which have none, are kept in FrozenPairs of the
+// interpreter.
+func (self Interp) Freeze(v Value, deep bool) {
+	self.freeze(v, deep, map[interface{}]bool{})
+}
+
+func (self Interp) freeze(v Value, deep bool, visited map[interface{}]bool) {
+	switch v.Type {
+	case ValPair:
+		if visited[identity(v)] {
+			return
+		}
+		visited[identity(v)] = true
+		self.FrozenPairs[v.PairLeft] = true
+		if deep {
+			self.freeze(*v.PairLeft, deep, visited)
+			self.freeze(*v.PairRight, deep, visited)
+		}
+	case ValVector, ValArray:
+		if visited[identity(v)] {
+			return
+		}
+		visited[identity(v)] = true
+		v.Vector.Frozen = true
+		if deep {
+			for _, item := range v.Vector.Items {
+				self.freeze(item, deep, visited)
+			}
+		}
+	}
+}
+
+// IsFrozen tells whether the pair or the vector is frozen, see Freeze.
+func (self Interp) IsFrozen(v Value) bool {
+	switch v.Type {
+	case ValPair:
+		return self.FrozenPairs[v.PairLeft]
+	case ValVector, ValArray:
+		return v.Vector.Frozen
+	}
+	return false
+}
+
+// MutableArg fails if the argument of the mutating procedure is frozen. The
+// error is located at the call, since the values built at run time have no
+// location.
+func (self Interp) MutableArg(v Value, name string) error {
+	if self.IsFrozen(v) {
+		_, err := self.NewEvalErrorOf(ErrFrozen, name, self.Call, fmt.Sprintf(
+			"`%v` can't modify frozen %v", name, Printer{Level: 2, Length: 4}.Sprint(v)))
+		return err
+	}
+	return nil
+}
+
+// freezeFn handles `(freeze! v ['shallow])`, freezing is deep by default.
+func freezeFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "freeze!", 1, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	deep := true
+	if len(args) == 2 {
+		if args[1].Type != ValSymbol || (args[1].Symbol != "shallow" && args[1].Symbol != "deep") {
+			return interp.NewEvalErrorOf(ErrWrongType, "freeze!", args[1], fmt.Sprintf(
+				"`freeze!` expects 'deep or 'shallow, given: %v", args[1]))
+		}
+		deep = args[1].Symbol == "deep"
+	}
+	interp.Freeze(args[0], deep)
+	return args[0], nil
+}
+
+func frozenFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "frozen?")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return Value{Type: ValBool, Bool: interp.IsFrozen(value)}, nil
+}
+
+func (self Interp) pairArg(v Value, name string) error {
+	if v.Type != ValPair {
+		_, err := self.NewEvalErrorOf(ErrWrongType, name, v, fmt.Sprintf(
+			"`%v` expects ValPair, given: %v", name, v))
+		return err
+	}
+	return self.MutableArg(v, name)
+}
+
+func setCarFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "set-car!", 2, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	if err := interp.pairArg(args[0], "set-car!"); err != nil {
+		return ValueNull(), err
+	}
+	*args[0].PairLeft = args[1]
+	return ValueNull(), nil
+}
+
+func setCdrFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "set-cdr!", 2, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	if err := interp.pairArg(args[0], "set-cdr!"); err != nil {
+		return ValueNull(), err
+	}
+	*args[0].PairRight = args[1]
+	return ValueNull(), nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DurationArg converts seconds, which are an integer or a decimal, into a
+// duration. The decimal may be given as a symbol or a string too, so that
+// (backoff . 0.5) means half of second in a quoted list of options.
+func (self Interp) DurationArg(v Value, name string) (time.Duration, error) {
+	var seconds Decimal
+	ok := true
+	switch v.Type {
+	case ValNumber:
+		seconds = DecimalFromInt(v.Number)
+	case ValDecimal:
+		seconds = v.Decimal
+	case ValSymbol:
+		seconds, ok = ParseDecimal(v.Symbol)
+	case ValString:
+		seconds, ok = ParseDecimal(v.StringData)
+	default:
+		ok = false
+	}
+	if !ok || seconds.Unscaled.Sign() < 0 {
+		_, err := self.NewEvalErrorOf(ErrWrongType, name, v, fmt.Sprintf(
+			"`%v` expects non-negative number of seconds, given: %v", name, v))
+		return 0, err
+	}
+	return time.Duration(seconds.Round(9, RoundHalfEven).Unscaled.Int64()), nil
+}
+
+// TokenBucket allows a burst of events at once and then the events at the
+// rate, which is the way most of the APIs limit their clients.
+type TokenBucket struct {
+	mutex sync.Mutex
+	// interval is the time it takes to get a token back
+	interval time.Duration
+	burst    int
+	tokens   float64
+	last     time.Time
+}
+
+func NewTokenBucket(interval time.Duration, burst int) *TokenBucket {
+	return &TokenBucket{interval: interval, burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// Wait takes a token, waiting for one if there are none. The tokens are
+// taken in the order of the calls, even if they are concurrent. Returns false
+// if done is closed before the token is available, the token is given back
+// then.
+func (self *TokenBucket) Wait(done <-chan struct{}) bool {
+	self.mutex.Lock()
+	now := time.Now()
+	if self.interval > 0 {
+		self.tokens += float64(now.Sub(self.last)) / float64(self.interval)
+	} else {
+		self.tokens = float64(self.burst)
+	}
+	if self.tokens > float64(self.burst) {
+		self.tokens = float64(self.burst)
+	}
+	self.last = now
+	// The token is reserved right away, so the concurrent callers wait for
+	// the tokens after this one
+	self.tokens--
+	wait := time.Duration(-self.tokens * float64(self.interval))
+	self.mutex.Unlock()
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		self.mutex.Lock()
+		self.tokens++
+		self.mutex.Unlock()
+		return false
+	}
+}
+
+// sleep waits for the delay, unless the context is done meanwhile, see
+// waitContext.
+func sleep(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// makeRateLimiterFn makes `(make-rate-limiter interval [burst])` limiter, which
+// is a procedure of no arguments returning when the next event is allowed.
+// The interval is in seconds between the events, the burst is the count of
+// events allowed at once, 1 by default.
+func makeRateLimiterFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "make-rate-limiter", 1, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	interval, err := interp.DurationArg(args[0], "make-rate-limiter")
+	if err != nil {
+		return ValueNull(), err
+	}
+	burst := 1
+	if len(args) == 2 {
+		if args[1].Type != ValNumber || args[1].Number < 1 {
+			return interp.NewEvalErrorOf(ErrWrongType, "make-rate-limiter", args[1], fmt.Sprintf(
+				"`make-rate-limiter` expects positive burst, given: %v", args[1]))
+		}
+		burst = args[1].Number
+	}
+	bucket := NewTokenBucket(interval, burst)
+	limiter := func(arg Value, interp Interp) (Value, error) {
+		if _, err := interp.RangeArgs(arg, "rate-limiter", 0, 0); err != nil {
+			return ValueNull(), err
+		}
+		ctx, cancel := interp.waitContext()
+		defer cancel()
+		if !bucket.Wait(ctx.Done()) {
+			return interp.canceledError("rate-limiter", ctx)
+		}
+		return ValueNull(), nil
+	}
+	return Value{Type: ValProc, Symbol: "rate-limiter", Proc: limiter}, nil
+}
+
+type retryOptions struct {
+	retries int
+	backoff time.Duration
+	timeout time.Duration
+	// limiter is a procedure called before every attempt, see
+	// `make-rate-limiter`
+	limiter *Value
+}
+
+func (self Interp) parseRetryOptions(list Value, name string) (retryOptions, error) {
+	options := retryOptions{retries: 3, backoff: time.Second, timeout: 30 * time.Second}
+	entries, err := self.RangeArgs(list, name, 0, MaxArgs)
+	if err != nil {
+		return options, err
+	}
+	for _, entry := range entries {
+		if entry.Type != ValPair || entry.PairLeft.Type != ValSymbol {
+			_, err := self.NewEvalError(entry, fmt.Sprintf(
+				"`%v` expects options like (retries . 3), given: %v", name, entry))
+			return options, err
+		}
+		value := *entry.PairRight
+		switch entry.PairLeft.Symbol {
+		case "retries":
+			if value.Type != ValNumber || value.Number < 0 {
+				_, err := self.NewEvalErrorOf(ErrWrongType, name, entry, fmt.Sprintf(
+					"`%v` expects non-negative count of retries, given: %v", name, value))
+				return options, err
+			}
+			options.retries = value.Number
+		case "backoff":
+			options.backoff, err = self.DurationArg(value, name)
+		case "timeout":
+			options.timeout, err = self.DurationArg(value, name)
+		case "limiter":
+			if _, err := self.ProcArg(value, name); err != nil {
+				return options, err
+			}
+			options.limiter = &value
+		default:
+			_, err = self.NewEvalError(entry, fmt.Sprintf(
+				"`%v` does not know option %v", name, entry.PairLeft.Symbol))
+		}
+		if err != nil {
+			return options, err
+		}
+	}
+	return options, nil
+}
+
+// retryable tells whether the attempt may succeed if repeated: the server
+// asks to slow down or is failing itself.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// httpGetRetryFn handles `(http-get/retry url [options])`, which returns the
+// body of the response as a string. Failed attempts are repeated after the
+// backoff, which doubles every time, unless the server tells how long to wait
+// with Retry-After header. Only the network errors, 429 and 5xx responses are
+// retried, the other responses fail right away.
+func httpGetRetryFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "http-get/retry", 1, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	url := args[0]
+	if url.Type != ValString {
+		return interp.NewEvalErrorOf(ErrWrongType, "http-get/retry", url, fmt.Sprintf(
+			"`http-get/retry` expects ValString URL, given: %v", url))
+	}
+	optionList := ValueNull()
+	if len(args) == 2 {
+		optionList = args[1]
+	}
+	options, err := interp.parseRetryOptions(optionList, "http-get/retry")
+	if err != nil {
+		return ValueNull(), err
+	}
+	request, err := http.NewRequest(http.MethodGet, url.StringData, nil)
+	if err != nil {
+		return interp.NewEvalError(url, fmt.Sprintf(
+			"`http-get/retry` can't get %v: %v", url.StringData, err))
+	}
+	ctx, cancel := interp.waitContext()
+	defer cancel()
+	request = request.WithContext(ctx)
+	client := &http.Client{Timeout: options.timeout}
+	backoff := options.backoff
+	var failure string
+	for attempt := 0; ; attempt++ {
+		if options.limiter != nil {
+			if _, err := interp.Apply(*options.limiter, ValueNull()); err != nil {
+				return ValueNull(), err
+			}
+		}
+		delay := backoff
+		response, err := client.Do(request)
+		if err != nil && ctx.Err() != nil {
+			return interp.canceledError("http-get/retry", ctx)
+		} else if err != nil {
+			failure = err.Error()
+		} else {
+			body, err := io.ReadAll(response.Body)
+			response.Body.Close()
+			switch {
+			case err != nil:
+				failure = err.Error()
+			case response.StatusCode >= 200 && response.StatusCode < 300:
+				return Value{Type: ValString, StringData: string(body)}, nil
+			case !retryable(response.StatusCode):
+				return interp.NewEvalError(interp.Call, fmt.Sprintf(
+					"`http-get/retry` got %v from %v", response.Status, url.StringData))
+			default:
+				failure = response.Status
+				if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+					delay = time.Duration(seconds) * time.Second
+				}
+			}
+		}
+		if attempt == options.retries {
+			break
+		}
+		if !sleep(ctx, delay) {
+			return interp.canceledError("http-get/retry", ctx)
+		}
+		backoff *= 2
+	}
+	return interp.NewEvalError(interp.Call, fmt.Sprintf(
+		"`http-get/retry` failed after %v attempts to get %v: %v",
+		options.retries+1, url.StringData, failure))
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConnectionInfo is the connection file Jupyter starts the kernel with.
+type ConnectionInfo struct {
+	Transport       string `json:"transport"`
+	IP              string `json:"ip"`
+	ShellPort       int    `json:"shell_port"`
+	IOPubPort       int    `json:"iopub_port"`
+	StdinPort       int    `json:"stdin_port"`
+	ControlPort     int    `json:"control_port"`
+	HBPort          int    `json:"hb_port"`
+	Key             string `json:"key"`
+	SignatureScheme string `json:"signature_scheme"`
+}
+
+type KernelHeader struct {
+	MsgID    string `json:"msg_id"`
+	Session  string `json:"session"`
+	Username string `json:"username"`
+	Date     string `json:"date"`
+	MsgType  string `json:"msg_type"`
+	Version  string `json:"version"`
+}
+
+// KernelMessage is a message of Jupyter messaging protocol, the content is
+// decoded by the handler of the message type.
+type KernelMessage struct {
+	Identities [][]byte
+	Header     KernelHeader
+	Parent     json.RawMessage
+	Content    json.RawMessage
+}
+
+const kernelDelimiter = "<IDS|MSG>"
+
+const kernelProtocolVersion = "5.3"
+
+// Kernel runs the cells in a single interpreter, so the definitions of a cell
+// are visible in the following ones. Requests are handled one at a time.
+type Kernel struct {
+	info    ConnectionInfo
+	session string
+	interp  Interp
+	options Options
+	iopub   *ZmtpPublisher
+	// count is the execution counter, In[count] is the name of the cell
+	count int
+	mutex sync.Mutex
+	done  chan bool
+	// cancel interrupts the cell being executed, it is guarded by its own
+	// mutex since the execution holds the other one, see interrupt
+	cancel      context.CancelFunc
+	cancelMutex sync.Mutex
+}
+
+// kernelYieldInterval is the number of steps between checks for interrupts.
+const kernelYieldInterval = 1000
+
+// interrupt cancels the cell being executed, if any. It does not wait for the
+// cell, which fails with ErrCanceled error at its next yield point.
+func (self *Kernel) interrupt() {
+	self.cancelMutex.Lock()
+	defer self.cancelMutex.Unlock()
+	if self.cancel != nil {
+		self.cancel()
+	}
+}
+
+func newID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (self *Kernel) sign(parts [][]byte) []byte {
+	if self.info.Key == "" {
+		return []byte{}
+	}
+	mac := hmac.New(sha256.New, []byte(self.info.Key))
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return []byte(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// decode verifies the signature and splits the frames of the message.
+func (self *Kernel) decode(frames [][]byte) (KernelMessage, error) {
+	var message KernelMessage
+	delimiter := -1
+	for i, frame := range frames {
+		if string(frame) == kernelDelimiter {
+			delimiter = i
+			break
+		}
+	}
+	if delimiter < 0 || len(frames) < delimiter+6 {
+		return message, fmt.Errorf("malformed message")
+	}
+	parts := frames[delimiter+2 : delimiter+6]
+	if !hmac.Equal(frames[delimiter+1], self.sign(parts)) {
+		return message, fmt.Errorf("invalid signature")
+	}
+	message.Identities = frames[:delimiter]
+	message.Parent = parts[0]
+	message.Content = parts[3]
+	return message, json.Unmarshal(parts[0], &message.Header)
+}
+
+// encode makes the frames of the message of the type replying to the parent.
+func (self *Kernel) encode(parent KernelMessage, msgType string, content interface{}) [][]byte {
+	header, _ := json.Marshal(KernelHeader{
+		MsgID:    newID(),
+		Session:  self.session,
+		Username: "golisp",
+		Date:     time.Now().UTC().Format("2006-01-02T15:04:05.000000Z"),
+		MsgType:  msgType,
+		Version:  kernelProtocolVersion,
+	})
+	parentHeader := []byte(parent.Parent)
+	if len(parentHeader) == 0 {
+		parentHeader = []byte("{}")
+	}
+	body, _ := json.Marshal(content)
+	parts := [][]byte{header, parentHeader, []byte("{}"), body}
+	frames := append([][]byte{}, parent.Identities...)
+	frames = append(frames, []byte(kernelDelimiter), self.sign(parts))
+	return append(frames, parts...)
+}
+
+func (self *Kernel) publish(parent KernelMessage, msgType string, content interface{}) {
+	frames := self.encode(parent, msgType, content)
+	// The topic replaces the identities of the requester
+	topic := [][]byte{[]byte("kernel." + self.session + "." + msgType)}
+	self.iopub.Publish(append(topic, frames[len(parent.Identities):]...))
+}
+
+func (self *Kernel) status(parent KernelMessage, state string) {
+	self.publish(parent, "status", map[string]string{"execution_state": state})
+}
+
+// serve handles the requests of a shell or control channel peer.
+func (self *Kernel) serve(peer *ZmtpConn) {
+	for {
+		frames, err := peer.ReadMessage()
+		if err != nil {
+			return
+		}
+		message, err := self.decode(frames)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kernel: %v\n", err)
+			continue
+		}
+		if message.Header.MsgType == "interrupt_request" {
+			// Handled right away, the execution to interrupt holds the mutex
+			self.interrupt()
+			peer.WriteMessage(self.encode(message, "interrupt_reply", map[string]string{"status": "ok"}))
+			continue
+		}
+		self.mutex.Lock()
+		self.status(message, "busy")
+		msgType, reply := self.handle(message)
+		if msgType != "" {
+			peer.WriteMessage(self.encode(message, msgType, reply))
+		}
+		self.status(message, "idle")
+		self.mutex.Unlock()
+		if message.Header.MsgType == "shutdown_request" {
+			self.done <- true
+		}
+	}
+}
+
+func (self *Kernel) handle(message KernelMessage) (string, interface{}) {
+	switch message.Header.MsgType {
+	case "kernel_info_request":
+		return "kernel_info_reply", map[string]interface{}{
+			"status":                 "ok",
+			"protocol_version":       kernelProtocolVersion,
+			"implementation":         "golisp",
+			"implementation_version": "0.1",
+			"language_info": map[string]string{
+				"name":           "scheme",
+				"version":        "r7rs-subset",
+				"mimetype":       "text/x-scheme",
+				"file_extension": ".scm",
+			},
+			"banner": "golisp",
+		}
+	case "execute_request":
+		var request struct {
+			Code   string `json:"code"`
+			Silent bool   `json:"silent"`
+		}
+		json.Unmarshal(message.Content, &request)
+		return "execute_reply", self.execute(message, request.Code, request.Silent)
+	case "is_complete_request":
+		var request struct {
+			Code string `json:"code"`
+		}
+		json.Unmarshal(message.Content, &request)
+		return "is_complete_reply", map[string]string{"status": IsComplete(request.Code)}
+	case "complete_request":
+		var request struct {
+			Code      string `json:"code"`
+			CursorPos int    `json:"cursor_pos"`
+		}
+		json.Unmarshal(message.Content, &request)
+		return "complete_reply", self.complete(request.Code, request.CursorPos)
+	case "inspect_request":
+		return "inspect_reply", map[string]interface{}{
+			"status": "ok", "found": false, "data": map[string]string{}, "metadata": map[string]string{},
+		}
+	case "history_request":
+		return "history_reply", map[string]interface{}{"status": "ok", "history": []interface{}{}}
+	case "comm_info_request":
+		return "comm_info_reply", map[string]interface{}{"status": "ok", "comms": map[string]interface{}{}}
+	case "shutdown_request":
+		var request struct {
+			Restart bool `json:"restart"`
+		}
+		json.Unmarshal(message.Content, &request)
+		return "shutdown_reply", map[string]interface{}{"status": "ok", "restart": request.Restart}
+	}
+	return "", nil
+}
+
+// IsComplete tells Jupyter whether the code can be executed as is: "complete",
+// "incomplete" if it ends in the middle of an expression, or "invalid".
+func IsComplete(code string) string {
+	var parser Pars
+	parser.Lex.Name = "<input>"
+	_, err := parser.ParseAll(strings.NewReader(code))
+	if e, ok := err.(Error); ok && e.Kind == ErrUnexpectedEOF {
+		return "incomplete"
+	} else if err != nil {
+		return "invalid"
+	}
+	return "complete"
+}
+
+// execute evaluates the cell, publishing the output of every expression and
+// the result of the last one, like REPL does.
+func (self *Kernel) execute(message KernelMessage, code string, silent bool) map[string]interface{} {
+	if !silent {
+		self.count++
+	}
+	count := self.count
+	if !silent {
+		self.publish(message, "execute_input", map[string]interface{}{
+			"code": code, "execution_count": count,
+		})
+	}
+	var parser Pars
+	parser.Lex.Name = fmt.Sprintf("In[%v]", count)
+	parser.Lex.FoldCase = self.options.FoldCase
+	interp := self.interp
+	interp.Register(&parser.Lex)
+	port := NewStringPort()
+	interp.Output = port
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	self.cancelMutex.Lock()
+	self.cancel = cancel
+	self.cancelMutex.Unlock()
+	defer func() {
+		self.cancelMutex.Lock()
+		self.cancel = nil
+		self.cancelMutex.Unlock()
+	}()
+	interp.Yielder = NewYielder(ctx, kernelYieldInterval)
+	flush := func() {
+		if text := port.Buffer.String(); text != "" && !silent {
+			self.publish(message, "stream", map[string]string{"name": "stdout", "text": text})
+		}
+		port.Buffer.Reset()
+	}
+	expressions, err := parser.ParseAll(strings.NewReader(code))
+	result := ValueNull()
+	for _, expression := range expressions {
+		if err != nil {
+			// Nothing is evaluated if the cell can't be parsed completely
+			break
+		}
+		result, err = interp.Eval(expression)
+		flush()
+	}
+	if err != nil {
+		record := NewErrorRecord(err, self.options.Teach)
+		traceback := []string{err.Error()}
+		if record.Explanation != "" {
+			traceback = append(traceback, record.Explanation)
+		}
+		content := map[string]interface{}{
+			"ename": record.Kind, "evalue": err.Error(), "traceback": traceback,
+		}
+		if !silent {
+			self.publish(message, "error", content)
+		}
+		content["status"] = "error"
+		content["execution_count"] = count
+		return content
+	}
+	if result.Type != ValNull && !silent {
+		// Like the results of REPL, but named after the cell
+		self.interp.Table[fmt.Sprintf("$%v", count)] = result
+		self.publish(message, "execute_result", map[string]interface{}{
+			"execution_count": count,
+			"data":            map[string]string{"text/plain": PrinterFromInterp(interp).Sprint(result)},
+			"metadata":        map[string]string{},
+		})
+	}
+	return map[string]interface{}{
+		"status": "ok", "execution_count": count, "user_expressions": map[string]string{},
+	}
+}
+
+// complete suggests the top level names starting with the identifier before
+// the cursor.
+func (self *Kernel) complete(code string, cursor int) map[string]interface{} {
+	if cursor > len(code) || cursor < 0 {
+		cursor = len(code)
+	}
+	start := cursor
+	for start > 0 && !strings.ContainsRune(" \t\r\n()'\"", rune(code[start-1])) {
+		start--
+	}
+	prefix := code[start:cursor]
+	matches := []string{}
+	for name := range self.interp.Table {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return map[string]interface{}{
+		"status": "ok", "matches": matches, "cursor_start": start, "cursor_end": cursor,
+		"metadata": map[string]string{},
+	}
+}
+
+func (self *Kernel) listen(port int) (net.Listener, error) {
+	return net.Listen("tcp", net.JoinHostPort(self.info.IP, fmt.Sprint(port)))
+}
+
+// RunKernel runs Jupyter kernel until it is shut down. Install it with
+// kernel.json like
+// {"argv": ["golisp", "kernel", "--connection-file", "{connection_file}"],
+// "display_name": "golisp", "language": "scheme", "interrupt_mode": "message"}
+func RunKernel(args []string, options Options) int {
+	flags := flag.NewFlagSet("kernel", flag.ExitOnError)
+	connectionFile := flags.String("connection-file", "", "Jupyter connection `file`")
+	flags.Parse(args)
+	data, err := os.ReadFile(*connectionFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kernel: %v\n", err)
+		return 2
+	}
+	kernel := &Kernel{session: newID(), interp: NewInterp(), options: options, done: make(chan bool)}
+	if err := json.Unmarshal(data, &kernel.info); err != nil {
+		fmt.Fprintf(os.Stderr, "kernel: %v: %v\n", *connectionFile, err)
+		return 2
+	}
+	if kernel.info.Transport != "tcp" {
+		fmt.Fprintf(os.Stderr, "kernel: unsupported transport %v\n", kernel.info.Transport)
+		return 2
+	}
+	if kernel.info.SignatureScheme != "" && kernel.info.SignatureScheme != "hmac-sha256" {
+		fmt.Fprintf(os.Stderr, "kernel: unsupported signature scheme %v\n", kernel.info.SignatureScheme)
+		return 2
+	}
+	kernel.interp.CommandLine = []string{os.Args[0]}
+	sockets := map[int]func(net.Listener){
+		kernel.info.ShellPort:   func(l net.Listener) { ZmtpServe(l, "ROUTER", kernel.serve) },
+		kernel.info.ControlPort: func(l net.Listener) { ZmtpServe(l, "ROUTER", kernel.serve) },
+		// Input requests are not supported, the channel is kept for clients
+		kernel.info.StdinPort: func(l net.Listener) {
+			ZmtpServe(l, "ROUTER", func(peer *ZmtpConn) {
+				for _, err := peer.ReadMessage(); err == nil; _, err = peer.ReadMessage() {
+				}
+			})
+		},
+		kernel.info.HBPort: func(l net.Listener) {
+			ZmtpServe(l, "REP", func(peer *ZmtpConn) {
+				for {
+					frames, err := peer.ReadMessage()
+					if err != nil || peer.WriteMessage(frames) != nil {
+						return
+					}
+				}
+			})
+		},
+	}
+	iopub, err := kernel.listen(kernel.info.IOPubPort)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kernel: %v\n", err)
+		return 1
+	}
+	kernel.iopub = NewZmtpPublisher(iopub)
+	for port, serve := range sockets {
+		listener, err := kernel.listen(port)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kernel: %v\n", err)
+			return 1
+		}
+		go serve(listener)
+	}
+	<-kernel.done
+	return 0
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Store is a key-value store persisted to an append-only file. Every change
+// is appended as `(set key value)` or `(delete key)` record written the way
+// `write` writes, and the file is replayed by the reader when opened. Keys and
+// values are any values that can be written and read back.
+type Store struct {
+	Path string
+	file *os.File
+	// entries are keyed by the written key, which is the same for equal keys
+	entries map[string]storeEntry
+}
+
+type storeEntry struct {
+	Key   Value
+	Value Value
+}
+
+// OpenStore opens the store, creating the file if there is none. An
+// incomplete record at the end of the file, which is left by a crash in the
+// middle of writing, is cut off.
+func OpenStore(path string) (*Store, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	store := &Store{Path: path, file: file, entries: map[string]storeEntry{}}
+	parser, records, err := ParseFile(path, false)
+	var e Error
+	if errors.As(err, &e) && e.Kind == ErrUnexpectedEOF {
+		end := 0
+		if len(records) > 0 {
+			_, end = Span(records[len(records)-1])
+		}
+		if err = file.Truncate(int64(end)); err == nil && end > 0 {
+			_, err = file.WriteString("\n")
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	for _, record := range records {
+		items, ok := elements(record)
+		if !ok || len(items) == 0 || items[0].Type != ValSymbol {
+			file.Close()
+			return nil, NewError(parser.Lex.Named(), record.Token.Offset, fmt.Sprintf(
+				"Broken store record: %v", Printer{}.Sprint(record)))
+		}
+		switch {
+		case items[0].Symbol == "set" && len(items) == 3:
+			store.entries[Printer{}.Sprint(items[1])] = storeEntry{items[1], items[2]}
+		case items[0].Symbol == "delete" && len(items) == 2:
+			delete(store.entries, Printer{}.Sprint(items[1]))
+		default:
+			file.Close()
+			return nil, NewError(parser.Lex.Named(), record.Token.Offset, fmt.Sprintf(
+				"Broken store record: %v", Printer{}.Sprint(record)))
+		}
+	}
+	return store, nil
+}
+
+// append writes the record and waits for it to reach the disk, so a change
+// is never lost once it is made.
+func (self *Store) append(record Value) error {
+	if self.file == nil {
+		return fmt.Errorf("store %v is closed", self.Path)
+	}
+	if _, err := self.file.WriteString(Printer{}.Sprint(record) + "\n"); err != nil {
+		return err
+	}
+	return self.file.Sync()
+}
+
+func (self *Store) Get(key Value) (Value, bool) {
+	entry, ok := self.entries[Printer{}.Sprint(key)]
+	return entry.Value, ok
+}
+
+func (self *Store) Set(key, value Value) error {
+	record := NewList([]Value{{Type: ValSymbol, Symbol: "set"}, key, value})
+	if err := self.append(record); err != nil {
+		return err
+	}
+	self.entries[Printer{}.Sprint(key)] = storeEntry{key, value}
+	return nil
+}
+
+func (self *Store) Delete(key Value) error {
+	written := Printer{}.Sprint(key)
+	if _, ok := self.entries[written]; !ok {
+		return nil
+	}
+	record := NewList([]Value{{Type: ValSymbol, Symbol: "delete"}, key})
+	if err := self.append(record); err != nil {
+		return err
+	}
+	delete(self.entries, written)
+	return nil
+}
+
+// Keys returns the keys sorted by their written form.
+func (self *Store) Keys() []Value {
+	written := make([]string, 0, len(self.entries))
+	for key := range self.entries {
+		written = append(written, key)
+	}
+	sort.Strings(written)
+	keys := make([]Value, len(written))
+	for i, key := range written {
+		keys[i] = self.entries[key].Key
+	}
+	return keys
+}
+
+func (self *Store) Close() error {
+	if self.file == nil {
+		return nil
+	}
+	err := self.file.Close()
+	self.file = nil
+	return err
+}
+
+// Readable tells whether the value is read back as an equal value after it
+// is written, which is not the case for procedures and ports.
+func Readable(v Value) bool {
+	var parser Pars
+	values, err := parser.ParseAll(strings.NewReader(Printer{}.Sprint(v)))
+	if err != nil || len(values) != 1 {
+		return false
+	}
+	return Equal(values[0], v)
+}
+
+func (self Interp) storeArg(v Value, name string) (*Store, error) {
+	if v.Type != ValStore {
+		_, err := self.NewEvalErrorOf(ErrWrongType, name, v, fmt.Sprintf(
+			"`%v` expects store, given: %v", name, v))
+		return nil, err
+	}
+	return v.Store, nil
+}
+
+// readableArg is located at the call, since the values that can't be read
+// back are not read from the source in the first place.
+func (self Interp) readableArg(v Value, name string) error {
+	if !Readable(v) {
+		_, err := self.NewEvalErrorOf(ErrWrongType, name, self.Call, fmt.Sprintf(
+			"`%v` expects a value that can be written and read back, given: %v", name, v))
+		return err
+	}
+	return nil
+}
+
+func kvOpenFn(arg Value, interp Interp) (Value, error) {
+	path, err := interp.SingleArg(arg, "kv-open")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if path.Type != ValString {
+		return interp.NewEvalErrorOf(ErrWrongType, "kv-open", path, fmt.Sprintf(
+			"`kv-open` expects ValString file name, given: %v", path))
+	}
+	store, err := OpenStore(path.StringData)
+	if err != nil {
+		return interp.NewEvalError(path, fmt.Sprintf("`kv-open` can't open store: %v", err))
+	}
+	return Value{Type: ValStore, Store: store}, nil
+}
+
+// kvGetFn returns the value of the key, or the default if there is none,
+// which is #f unless given.
+func kvGetFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "kv-get", 2, 3)
+	if err != nil {
+		return ValueNull(), err
+	}
+	store, err := interp.storeArg(args[0], "kv-get")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if value, ok := store.Get(args[1]); ok {
+		return value, nil
+	}
+	if len(args) == 3 {
+		return args[2], nil
+	}
+	return Value{Type: ValBool, Bool: false}, nil
+}
+
+func kvSetFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "kv-set!", 3, 3)
+	if err != nil {
+		return ValueNull(), err
+	}
+	store, err := interp.storeArg(args[0], "kv-set!")
+	if err != nil {
+		return ValueNull(), err
+	}
+	for _, v := range args[1:] {
+		if err := interp.readableArg(v, "kv-set!"); err != nil {
+			return ValueNull(), err
+		}
+	}
+	if err := store.Set(args[1], args[2]); err != nil {
+		return interp.NewEvalError(interp.Call, fmt.Sprintf("`kv-set!` failed: %v", err))
+	}
+	return ValueNull(), nil
+}
+
+func kvDeleteFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "kv-delete!", 2, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	store, err := interp.storeArg(args[0], "kv-delete!")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if err := store.Delete(args[1]); err != nil {
+		return interp.NewEvalError(interp.Call, fmt.Sprintf("`kv-delete!` failed: %v", err))
+	}
+	return ValueNull(), nil
+}
+
+func kvKeysFn(arg Value, interp Interp) (Value, error) {
+	v, err := interp.SingleArg(arg, "kv-keys")
+	if err != nil {
+		return ValueNull(), err
+	}
+	store, err := interp.storeArg(v, "kv-keys")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return NewList(store.Keys()), nil
+}
+
+func kvCloseFn(arg Value, interp Interp) (Value, error) {
+	v, err := interp.SingleArg(arg, "kv-close")
+	if err != nil {
+		return ValueNull(), err
+	}
+	store, err := interp.storeArg(v, "kv-close")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if err := store.Close(); err != nil {
+		return interp.NewEvalError(v, fmt.Sprintf("`kv-close` failed: %v", err))
+	}
+	return ValueNull(), nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import "fmt"
+
+// DefineMacro handles `(define-macro (name . params) body...)`. The macro is a
+// procedure receiving unevaluated arguments of the form, the value it returns
+// is evaluated in place of the form.
+func (self *Interp) DefineMacro(arg Value) (Value, error) {
+	if arg.Type != ValPair || arg.PairLeft.Type != ValPair {
+		return self.NewEvalError(arg, fmt.Sprintf(
+			"`define-macro` expects (name . params) and body, given: %v", arg))
+	}
+	left := *arg.PairLeft
+	name := *left.PairLeft
+	if name.Type != ValSymbol {
+		return self.NewEvalError(name, fmt.Sprintf(
+			"`define-macro` expects ValSymbol macro name, given: %v", name))
+	}
+	macro, err := self.syntaxInterp().Lambda(name.Symbol, *left.PairRight, *arg.PairRight)
+	if err != nil {
+		return macro, err
+	}
+	macro.Type = ValMacro
+	if err := self.Bind(name.Symbol, macro, name); err != nil {
+		return ValueNull(), err
+	}
+	return ValueNull(), nil
+}
+
+// syntaxInterp returns the interpreter to evaluate expansion-time code with.
+func (self *Interp) syntaxInterp() *Interp {
+	interp := *self
+	if interp.SyntaxEnv != nil {
+		interp.Env = interp.SyntaxEnv
+	}
+	return &interp
+}
+
+// BeginForSyntax evaluates the body in the expansion-time scope, so the
+// definitions are visible to macros only.
+func (self *Interp) BeginForSyntax(body Value) (Value, error) {
+	return self.syntaxInterp().Begin(body)
+}
+
+// EvalWhen handles `(eval-when (situation...) body...)`. The body is evaluated
+// in the expansion-time scope for `expand` situation and in the current scope
+// for `eval` and `load` situations, in both scopes if both are given.
+func (self *Interp) EvalWhen(arg Value) (Value, error) {
+	if arg.Type != ValPair {
+		return self.NewEvalError(arg, fmt.Sprintf(
+			"`eval-when` expects situations and body, given: %v", arg))
+	}
+	var expand, eval bool
+	situations, err := self.RangeArgs(*arg.PairLeft, "eval-when", 0, MaxArgs)
+	if err != nil {
+		return ValueNull(), err
+	}
+	for _, situation := range situations {
+		switch situation.Symbol {
+		case "expand", "compile":
+			expand = true
+		case "eval", "load":
+			eval = true
+		default:
+			return self.NewEvalError(situation, fmt.Sprintf(
+				"`eval-when` expects expand, compile, eval or load situation, given: %v",
+				situation))
+		}
+	}
+	result := ValueNull()
+	if expand {
+		result, err = self.BeginForSyntax(*arg.PairRight)
+		if err != nil {
+			return result, err
+		}
+	}
+	if eval {
+		result, err = self.Begin(*arg.PairRight)
+	}
+	return result, err
+}
+
+// Expand applies the macro to the form and attaches the location of the form to
+// the produced code, see AttachSyntax.
+func (self *Interp) Expand(macro Value, form Value) (Value, error) {
+	expanded, err := macro.Proc(*form.PairRight, *self)
+	if err != nil {
+		return expanded, err
+	}
+	start, end := Span(form)
+	return AttachSyntax(expanded, form.Token, start, end), nil
+}
+
+// Span returns the range of source offsets occupied by the tokens of the
+// value. The token of a list read by the parser spans the whole list, but the
+// lists built by the program have to be measured by their elements. Both
+// offsets are -1 if the value has no location at all.
+func Span(v Value) (int, int) {
+	start, end := -1, -1
+	if v.Token.Type != TokInvalid {
+		start, end = v.Token.Offset, v.Token.Offset+v.Token.Length
+	}
+	if v.Type == ValPair {
+		for _, child := range []*Value{v.PairLeft, v.PairRight} {
+			childStart, childEnd := Span(*child)
+			if childStart < 0 {
+				continue
+			}
+			if start < 0 || childStart < start {
+				start = childStart
+			}
+			if childEnd > end {
+				end = childEnd
+			}
+		}
+	}
+	return start, end
+}
+
+// AttachSyntax makes a copy of the macro expansion, where every node that does
+// not come from the macro call form itself (i.e. its location is outside of
+// [start, end) range of the source of the call) refers to the location of the
+// macro call. The nodes that are built by the macro, or taken from its
+// definition, would have either no location or location in the macro
+// definition otherwise, so the errors in the expanded code would be reported
+// at some meaningless place instead of the place of the macro use.
+func AttachSyntax(v Value, site Token, start, end int) Value {
+	offset := v.Token.Offset
+	if v.Token.Type == TokInvalid || v.Token.Source != site.Source ||
+		offset < start || offset >= end {
+		v.Token = site
+	}
+	if v.Type == ValPair {
+		left := AttachSyntax(*v.PairLeft, site, start, end)
+		right := AttachSyntax(*v.PairRight, site, start, end)
+		v.PairLeft, v.PairRight = &left, &right
+	}
+	return v
+}
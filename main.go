@@ -1,11 +1,17 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
 type TokenType int
@@ -19,12 +25,16 @@ const (
 	TokRparen
 	TokDot
 	TokQuote
+	TokHashLparen
 )
 
 type Token struct {
 	Offset int
 	Length int
 	Type   TokenType
+	// Source is the source the token was read from, nil for the tokens that
+	// are not read by a lexer
+	Source *NamedSource
 }
 
 type TokensFormatter struct {
@@ -44,9 +54,16 @@ const (
 )
 
 type Lex struct {
+	// Name is the name of the source, i.e. the file name, used in errors
+	Name   string
 	Source strings.Builder
 	Tokens []Token
-	state  LexState
+	// FoldCase makes symbols case-insensitive, i.e. they are folded into
+	// lower case. It is toggled by "#!fold-case" and "#!no-fold-case"
+	// directives in the source.
+	FoldCase bool
+	state    LexState
+	named    *NamedSource
 }
 
 type Pars struct {
@@ -54,10 +71,36 @@ type Pars struct {
 	tokens []Token
 }
 
+type ErrorKind int
+
+// Error kinds tell what kind of mistake has been made, so it can be explained
+// to the user in detail, see Error.Explain.
+const (
+	ErrGeneric ErrorKind = iota
+	ErrUnexpectedByte
+	ErrUnexpectedToken
+	ErrUnexpectedRparen
+	ErrUnexpectedEOF
+	ErrUnboundVariable
+	ErrNotProcedure
+	ErrArity
+	ErrWrongType
+	ErrContract
+	ErrProtected
+	ErrLimit
+	ErrCanceled
+	ErrFrozen
+)
+
 type Error struct {
+	FileName     string
 	LineNumber   int
 	OffsetInLine int
 	Text         string
+	Kind         ErrorKind
+	// Subject is what the error is about, e.g. the name of the unbound
+	// variable, used to explain the error.
+	Subject string
 }
 
 type ValueType int
@@ -71,6 +114,17 @@ const (
 	ValChar
 	ValString
 	ValProc
+	ValDecimal
+	ValComplex
+	ValVector
+	ValArray
+	ValPort
+	ValMacro
+	ValStore
+	ValRandom
+	ValChannel
+	// ValEOF is the end of file object, see `read` and `channel-get`
+	ValEOF
 )
 
 type Value struct {
@@ -84,15 +138,76 @@ type Value struct {
 	Char       byte
 	StringData string
 	Proc       func(Value, Interp) (Value, error)
+	Decimal    Decimal
+	Complex    *Complex
+	Vector     *Vector
+	Port       *Port
+	Store      *Store
+	Random     *RandomSource
+	Channel    *Channel
+	// Fixnum is set for builtin procedures having fast path, see EvalFixnum
+	Fixnum FixnumOp
 }
 
-type Interp struct {
-	Source *strings.Builder
+// Env is a lexical scope created by a procedure call. The outermost scope is
+// Interp.Table, which is not an Env.
+type Env struct {
 	Table  map[string]Value
+	Parent *Env
+}
+
+type Interp struct {
+	// SourceName is the name of the current source, see Register
+	SourceName string
+	Sources    Sources
+	Table      map[string]Value
+	// Env is the innermost lexical scope, nil at the top level
+	Env *Env
+	// Output is the current output port, standard output if nil
+	Output *Port
+	// SyntaxEnv is the expansion-time scope, where macros are evaluated. It
+	// sees the top level, but not the other way around. Macros are evaluated in
+	// the lexical scope of their definition if nil.
+	SyntaxEnv *Env
+	// Dribble is set in REPL only, see `dribble` procedure
+	Dribble *Dribble
+	// History is set in REPL only, see `,history` command
+	History *History
+	// Trace is set to trace the evaluation, see Tracer
+	Trace *Tracer
+	// Call is the innermost procedure call being evaluated, it locates the
+	// errors of the procedure that are not caused by a particular argument
+	Call Value
+	// CommandLine is the command name followed by arguments, see
+	// `command-line` procedure
+	CommandLine []string
+	// Definitions is the source text of the top level definitions by name,
+	// see RecordDefinition
+	Definitions map[string]string
+	// Protected are the top level names that can't be rebound, see Protect
+	Protected map[string]bool
+	// FrozenPairs are the pairs frozen by Freeze. A pair has no shared header
+	// like Vector, all of the copies of a pair share its car and cdr only, so
+	// it is kept by the address of its car. The pairs are released together
+	// with the interpreter.
+	FrozenPairs map[*Value]bool
+	// ShadowWarning is called, if set, when a definition or a procedure
+	// parameter hides or replaces a binding visible at its place. The warning
+	// is located at the name.
+	ShadowWarning func(name string, warning error)
+	// Limits are the step and time limits of the current form, see RunBatch
+	Limits *Limits
+	// Yielder is set by the programs embedding the interpreter to make it
+	// yield to other goroutines and to cancel it, see Yielder
+	Yielder *Yielder
 }
 
 func (e Error) Error() string {
-	return fmt.Sprintf("<stdin>:%v:%v: %v", e.LineNumber, e.OffsetInLine, e.Text)
+	fileName := e.FileName
+	if fileName == "" {
+		fileName = "<stdin>"
+	}
+	return fmt.Sprintf("%v:%v:%v: %v", fileName, e.LineNumber, e.OffsetInLine, e.Text)
 }
 
 func (self Value) assertType(valueType ValueType) {
@@ -101,27 +216,20 @@ func (self Value) assertType(valueType ValueType) {
 	}
 }
 
-func NewError(source string, offset int, text string) Error {
-	var line int = 1
-	var offsetInLine int
-	var prev byte
-	for i, c := range source {
-		if i == offset {
-			// The length of source may exceed greatly the offset of error location.
-			// That's why we break here when the desired offset has been reached.
-			break
-		}
-		offsetInLine += 1
-		if prev == '\r' && c == '\n' {
-			// Do not increment line number, because line was already incremented while
-			// we were parsing single '\r' (see next else-if branch).
-			offsetInLine = 0
-		} else if c == '\r' || c == '\n' {
-			line += 1
-			offsetInLine = 0
-		}
+// NewError creates an error located at the offset of the source, which may be
+// nil for the errors having no location.
+func NewError(source *NamedSource, offset int, text string) Error {
+	if source == nil {
+		return Error{LineNumber: 1, OffsetInLine: 1, Text: text}
 	}
-	return Error{line, offsetInLine + 1, text}
+	line, column := source.PositionFor(offset)
+	return Error{FileName: source.Name, LineNumber: line, OffsetInLine: column, Text: text}
+}
+
+func (e Error) WithKind(kind ErrorKind, subject string) Error {
+	e.Kind = kind
+	e.Subject = subject
+	return e
 }
 
 func (t ValueType) String() string {
@@ -142,6 +250,26 @@ func (t ValueType) String() string {
 		return "ValString"
 	case ValProc:
 		return "ValProc"
+	case ValDecimal:
+		return "ValDecimal"
+	case ValComplex:
+		return "ValComplex"
+	case ValVector:
+		return "ValVector"
+	case ValArray:
+		return "ValArray"
+	case ValPort:
+		return "ValPort"
+	case ValMacro:
+		return "ValMacro"
+	case ValStore:
+		return "ValStore"
+	case ValRandom:
+		return "ValRandom"
+	case ValChannel:
+		return "ValChannel"
+	case ValEOF:
+		return "ValEOF"
 	}
 	panic(fmt.Sprintf("Unknown Value type %d", t))
 }
@@ -152,8 +280,10 @@ func (v Value) String() string {
 		return fmt.Sprintf("%v<()>", v.Type)
 	case ValBool:
 		return fmt.Sprintf("%v<%t>", v.Type, v.Bool)
-	case ValPair:
-		return fmt.Sprintf("%v<(%v . %v)>", v.Type, v.PairLeft, v.PairRight)
+	case ValPair, ValVector, ValArray:
+		var sb strings.Builder
+		writeNested(&sb, v, map[interface{}]bool{})
+		return sb.String()
 	case ValSymbol:
 		return fmt.Sprintf("%v<%s>", v.Type, v.Symbol)
 	case ValNumber:
@@ -161,13 +291,62 @@ func (v Value) String() string {
 	case ValChar:
 		return fmt.Sprintf("%v<%c>", v.Type, v.Char)
 	case ValString:
-		return fmt.Sprintf("%v<%s>", v.Type, v.StringData)
+		return fmt.Sprintf("%v<%q>", v.Type, v.StringData)
 	case ValProc:
-		panic("String() for ValProc is not implemented")
+		return fmt.Sprintf("%v<%s>", v.Type, v.Symbol)
+	case ValDecimal:
+		return fmt.Sprintf("%v<#d%v>", v.Type, v.Decimal)
+	case ValComplex:
+		return fmt.Sprintf("%v<%v>", v.Type, v.Complex)
+	case ValPort:
+		return fmt.Sprintf("%v<%s>", v.Type, v.Port.Name)
+	case ValMacro:
+		return fmt.Sprintf("%v<%s>", v.Type, v.Symbol)
+	case ValStore:
+		return fmt.Sprintf("%v<%s>", v.Type, v.Store.Path)
+	case ValRandom:
+		return fmt.Sprintf("%v<>", v.Type)
+	case ValChannel:
+		return fmt.Sprintf("%v<>", v.Type)
+	case ValEOF:
+		return fmt.Sprintf("%v<>", v.Type)
 	}
 	panic(fmt.Sprintf("Unknown Value type %d", v.Type))
 }
 
+// writeNested writes Value.String() of pairs and vectors. The nodes reached
+// again while they are on the path are cycles, they are written as
+// ValPair<...> so that the writing ends.
+func writeNested(sb *strings.Builder, v Value, path map[interface{}]bool) {
+	id := identity(v)
+	if id == nil {
+		sb.WriteString(v.String())
+		return
+	}
+	if path[id] {
+		fmt.Fprintf(sb, "%v<...>", v.Type)
+		return
+	}
+	path[id] = true
+	defer delete(path, id)
+	switch v.Type {
+	case ValPair:
+		fmt.Fprintf(sb, "%v<(", v.Type)
+		writeNested(sb, *v.PairLeft, path)
+		sb.WriteString(" . ")
+		writeNested(sb, *v.PairRight, path)
+		sb.WriteString(")>")
+	case ValVector:
+		fmt.Fprintf(sb, "%v<", v.Type)
+		v.Vector.writeItems(sb, path)
+		sb.WriteString(">")
+	case ValArray:
+		fmt.Fprintf(sb, "%v<%v ", v.Type, v.Vector.Dims)
+		v.Vector.writeItems(sb, path)
+		sb.WriteString(">")
+	}
+}
+
 func (token Token) String() string {
 	switch token.Type {
 	case TokInvalid:
@@ -186,6 +365,8 @@ func (token Token) String() string {
 		return "TokDot"
 	case TokQuote:
 		return "TokQuote"
+	case TokHashLparen:
+		return "TokHashLparen"
 	}
 	panic(fmt.Sprintf("Unknown token type %v", token.Type))
 }
@@ -214,20 +395,20 @@ func (self *Lex) AddToken(t TokenType) []Token {
 		self.state = LexIdle
 		newTokens = append(newTokens, self.LastToken())
 	}
-	newToken := Token{self.Source.Len(), 1, t}
+	newToken := Token{self.Source.Len(), 1, t, self.Named()}
 	newTokens = append(newTokens, newToken)
 	self.Tokens = append(self.Tokens, newToken)
 	return newTokens
 }
 
 func (self *Lex) BeginNumber() {
-	newToken := Token{self.Source.Len(), 1, TokNumber}
+	newToken := Token{self.Source.Len(), 1, TokNumber, self.Named()}
 	self.Tokens = append(self.Tokens, newToken)
 	self.state = LexNumber
 }
 
 func (self *Lex) BeginIdentifier() {
-	newToken := Token{self.Source.Len(), 1, TokIdentifier}
+	newToken := Token{self.Source.Len(), 1, TokIdentifier, self.Named()}
 	self.Tokens = append(self.Tokens, newToken)
 	self.state = LexIdentifier
 }
@@ -237,7 +418,7 @@ func (self *Lex) BeginString() []Token {
 	if self.state != LexIdle {
 		newTokens = append(newTokens, self.LastToken())
 	}
-	newToken := Token{self.Source.Len(), 1, TokString}
+	newToken := Token{self.Source.Len(), 1, TokString, self.Named()}
 	self.Tokens = append(self.Tokens, newToken)
 	self.state = LexString
 	return newTokens
@@ -252,7 +433,7 @@ func IsAlphabetic(c byte) bool {
 		c == 'y' || c == 'z' || c == '-' || c == '!' || c == '$' || c == '%' || c == '*' ||
 		c == '+' || c == '?' || c == '&' || c == '.' || c == '\\' || c == '/' || c == '~' ||
 		c == '`' || c == ':' || c == '=' || c == '<' || c == '>' ||
-		c == '^' || c == '#'
+		c == '^' || c == '#' || c == '_'
 }
 
 func IsCommentCharacter(c byte) bool {
@@ -290,13 +471,15 @@ func TokenFromByte(c byte) TokenType {
 }
 
 func (self Lex) NewUnexpectedByteError(c byte) error {
-	var text string
+	var subject string
 	if IsPrintableCharacter(c) {
-		text = fmt.Sprintf("unexpected byte '%c'", c)
+		subject = fmt.Sprintf("'%c'", c)
 	} else {
-		text = fmt.Sprintf("unexpected byte 0x%X", c)
+		subject = fmt.Sprintf("0x%X", c)
 	}
-	return NewError(self.Source.String(), self.Source.Len(), text)
+	text := fmt.Sprintf("unexpected byte %v", subject)
+	return NewError(self.Named(), self.Source.Len(), text).
+		WithKind(ErrUnexpectedByte, subject)
 }
 
 func (self *Lex) LastTokenMut() *Token {
@@ -326,7 +509,12 @@ func (self *Lex) ConsumeImpl(c byte) ([]Token, error) {
 			return []Token{}, self.NewUnexpectedByteError(c)
 		}
 	case LexNumber:
-		if IsSingleCharToken(c) {
+		if c == '.' {
+			// Dot inside of a token is a part of the token, like in "#d1.23"
+			self.LastTokenMut().Length += 1
+			self.LastTokenMut().Type = TokIdentifier
+			self.state = LexIdentifier
+		} else if IsSingleCharToken(c) {
 			return self.AddToken(TokenFromByte(c)), nil
 		} else if c == '"' {
 			self.BeginString()
@@ -346,7 +534,16 @@ func (self *Lex) ConsumeImpl(c byte) ([]Token, error) {
 			return []Token{self.LastToken()}, self.NewUnexpectedByteError(c)
 		}
 	case LexIdentifier:
-		if IsSingleCharToken(c) {
+		if c == '.' {
+			self.LastTokenMut().Length += 1
+		} else if c == '(' && self.LastToken().Length == 1 &&
+			self.Source.String()[self.LastToken().Offset] == '#' {
+			// Vector literal opening "#("
+			self.LastTokenMut().Length += 1
+			self.LastTokenMut().Type = TokHashLparen
+			self.state = LexIdle
+			return []Token{self.LastToken()}, nil
+		} else if IsSingleCharToken(c) {
 			return self.AddToken(TokenFromByte(c)), nil
 		} else if c == '"' {
 			self.BeginString()
@@ -413,25 +610,71 @@ func ValueFromToken(lex Lex, token Token) (Value, error) {
 		number, err := strconv.Atoi(repr)
 		if err != nil {
 			return Value{Type: ValNull}, NewError(
-				lex.Source.String(),
+				token.Source,
 				token.Offset,
 				fmt.Sprintf("Can't parse number %v", tokenFormatted))
 		}
 		return Value{Type: ValNumber, Number: number, Token: token}, nil
 	case TokIdentifier:
+		if lex.FoldCase {
+			repr = FoldCase(repr)
+		}
 		if "#f" == repr {
 			return Value{Type: ValBool, Bool: false, Token: token}, nil
 		}
 		if "#t" == repr {
 			return Value{Type: ValBool, Bool: true, Token: token}, nil
 		}
+		if number, err := strconv.Atoi(repr); err == nil {
+			// Signed numbers like "-5" are lexed as identifiers
+			return Value{Type: ValNumber, Number: number, Token: token}, nil
+		}
+		if complex, ok := ParseComplex(repr); ok {
+			return Value{Type: ValComplex, Complex: &complex, Token: token}, nil
+		}
+		if strings.HasPrefix(repr, "#d") {
+			decimal, ok := ParseDecimal(repr[2:])
+			if !ok {
+				return Value{Type: ValNull}, NewError(
+					token.Source,
+					token.Offset,
+					fmt.Sprintf("Can't parse decimal %v", tokenFormatted))
+			}
+			return Value{Type: ValDecimal, Decimal: decimal, Token: token}, nil
+		}
 		return Value{Type: ValSymbol, Symbol: repr, Token: token}, nil
 	case TokString:
-		return Value{Type: ValString, StringData: repr, Token: token}, nil
+		return Value{Type: ValString, StringData: UnescapeString(repr), Token: token}, nil
 	}
 	panic(fmt.Sprintf("Cannot convert %v to Value", tokenFormatted))
 }
 
+// UnescapeString converts string literal representation into the string
+// itself, i.e. strips the quotes and resolves escape sequences. The lexer
+// guarantees that the literal is well formed.
+func UnescapeString(repr string) string {
+	var sb strings.Builder
+	repr = repr[1 : len(repr)-1]
+	for i := 0; i < len(repr); i++ {
+		c := repr[i]
+		if c == '\\' {
+			i++
+			switch repr[i] {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case 'r':
+				c = '\r'
+			default:
+				c = repr[i]
+			}
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
 func ValueNull() Value {
 	return Value{Type: ValNull}
 }
@@ -440,33 +683,75 @@ func NewNode(left, right *Value) *Value {
 	return &Value{Type: ValPair, PairLeft: left, PairRight: right}
 }
 
+// NewList creates proper list of the values.
+func NewList(items []Value) Value {
+	list := Value{Type: ValNull}
+	for i := len(items) - 1; i >= 0; i-- {
+		left, right := items[i], list
+		list = *NewNode(&left, &right)
+	}
+	return list
+}
+
+// directive handles "#!fold-case" and "#!no-fold-case" reader directives and
+// tells whether the token is a directive, which is skipped then.
+func (self *Pars) directive(token Token) bool {
+	if token.Type != TokIdentifier {
+		return false
+	}
+	switch self.Lex.Source.String()[token.Offset : token.Offset+token.Length] {
+	case "#!fold-case":
+		self.Lex.FoldCase = true
+		return true
+	case "#!no-fold-case":
+		self.Lex.FoldCase = false
+		return true
+	}
+	return false
+}
+
 func (self *Pars) NextToken(input io.Reader) (Token, error) {
 	for {
 		if len(self.tokens) > 0 {
 			token := self.tokens[0]
 			self.tokens = self.tokens[1:]
+			if self.directive(token) {
+				continue
+			}
 			return token, nil
 		}
 		var c []byte = []byte{0}
 		_, err := input.Read(c)
+		if err == io.EOF && (self.Lex.state == LexNumber || self.Lex.state == LexIdentifier) {
+			// The last token of the input is complete, because there is
+			// nothing left to continue it with.
+			self.Lex.state = LexIdle
+			self.tokens = append(self.tokens, self.Lex.LastToken())
+			continue
+		}
 		if err != nil {
-			return Token{0, 0, TokInvalid}, err
+			return Token{Type: TokInvalid}, err
 		}
 		newTokens, err := self.Lex.Consume(c[0])
 		if err != nil {
-			return Token{0, 0, TokInvalid}, err
+			return Token{Type: TokInvalid}, err
 		}
 		self.tokens = append(self.tokens, newTokens...)
 	}
 }
 
 func (self Pars) NewUnexpectedTokenError(token Token) error {
+	kind := ErrUnexpectedToken
+	if token.Type == TokRparen {
+		kind = ErrUnexpectedRparen
+	}
 	return NewError(
-		self.Lex.Source.String(),
+		self.Lex.Named(),
 		token.Offset,
 		fmt.Sprintf(
 			"Unexpected token %v",
-			TokensFormatter{self.Lex.Source.String(), []Token{token}}.String()))
+			TokensFormatter{self.Lex.Source.String(), []Token{token}}.String())).
+		WithKind(kind, self.Lex.Source.String()[token.Offset:token.Offset+token.Length])
 }
 
 func (self *Pars) ParseRemainingList(input io.Reader, quotedMode bool) (*Value, error) {
@@ -480,10 +765,9 @@ func (self *Pars) ParseRemainingList(input io.Reader, quotedMode bool) (*Value,
 			return pseudoRoot.PairRight, err
 		}
 		if token.Type == TokDot {
-			if quotedMode == false {
-				// Dots permitted in quoted mode only
-				return pseudoRoot.PairRight, self.NewUnexpectedTokenError(token)
-			}
+			// Dotted lists are permitted in non-quoted mode too, because they
+			// are needed for rest parameters of procedures, like in
+			// `(lambda (a . rest) rest)`. Evaluator takes care of the rest.
 			// A pretty much determined sequence is expected here after we got the
 			// TokDot token type
 			right, err := self.Parse(input, quotedMode)
@@ -535,10 +819,9 @@ func (self *Pars) ParseWithToken(input io.Reader, parentToken Token, quotedMode
 			return ValueNull(), err
 		}
 		if token2.Type == TokRparen {
-			if quotedMode {
-				return Value{Type: ValNull, Token: token}, nil
-			}
-			return ValueNull(), self.NewUnexpectedTokenError(token2)
+			// Empty list is needed in non-quoted mode for procedures without
+			// parameters, like in `(lambda () 1)`.
+			return Value{Type: ValNull, Token: token}, nil
 		}
 		left, err := self.ParseWithToken(input, token2, quotedMode)
 		if err != nil {
@@ -561,7 +844,32 @@ func (self *Pars) ParseWithToken(input io.Reader, parentToken Token, quotedMode
 			return ValueNull(), self.NewUnexpectedTokenError(token3)
 		}
 		right, err := self.ParseRemainingList(input, quotedMode)
-		return *NewNode(&left, right), err
+		list := *NewNode(&left, right)
+		list.Token = token
+		if err == nil {
+			// The token of the list spans up to the closing parenthesis, which
+			// has just been read
+			list.Token.Length = self.Lex.Source.Len() - token.Offset
+		}
+		return list, err
+	case TokHashLparen:
+		// Vector elements are not evaluated, hence parsing in quoted mode
+		list, err := self.ParseRemainingList(input, true)
+		if err != nil {
+			return ValueNull(), err
+		}
+		var items []Value
+		for list.Type == ValPair {
+			items = append(items, *list.PairLeft)
+			list = list.PairRight
+		}
+		if list.Type != ValNull {
+			return ValueNull(), NewError(
+				self.Lex.Named(),
+				token.Offset,
+				"Vector literal must be a proper list")
+		}
+		return Value{Type: ValVector, Vector: &Vector{Items: items}, Token: token}, nil
 	case TokQuote:
 		quoted, err := self.Parse(input, true)
 		return *NewNode(
@@ -572,11 +880,89 @@ func (self *Pars) ParseWithToken(input io.Reader, parentToken Token, quotedMode
 }
 
 func (self *Pars) Parse(input io.Reader, quoted bool) (Value, error) {
-	return self.ParseWithToken(input, Token{0, 0, TokInvalid}, quoted)
+	return self.ParseWithToken(input, Token{Type: TokInvalid}, quoted)
+}
+
+// ParseFile parses all the expressions of the file. Unlike Parse it reports
+// the end of file in the middle of an expression as an error.
+func ParseFile(path string, foldCase bool) (*Pars, []Value, error) {
+	var parser Pars
+	parser.Lex.Name = path
+	parser.Lex.FoldCase = foldCase
+	file, err := os.Open(path)
+	if err != nil {
+		return &parser, nil, err
+	}
+	defer file.Close()
+	expressions, err := parser.ParseAll(bufio.NewReader(file))
+	return &parser, expressions, err
+}
+
+// ParseAll parses all the expressions of the input. The end of input in the
+// middle of an expression is reported as ErrUnexpectedEOF error, which tells
+// that the input is incomplete.
+func (self *Pars) ParseAll(input io.Reader) ([]Value, error) {
+	var expressions []Value
+	for {
+		expression, err := self.ParseNext(input)
+		if err == io.EOF {
+			return expressions, nil
+		} else if err != nil {
+			return expressions, err
+		}
+		expressions = append(expressions, expression)
+	}
+}
+
+// ParseNext parses the next expression of the input. It returns io.EOF at the
+// end of input between expressions and ErrUnexpectedEOF error in the middle
+// of an expression.
+func (self *Pars) ParseNext(input io.Reader) (Value, error) {
+	tokensBefore := len(self.Lex.Tokens)
+	expression, err := self.Parse(input, false)
+	if err == io.EOF && (len(self.Lex.Tokens) != tokensBefore || self.Lex.state != LexIdle) {
+		return expression, NewError(
+			self.Lex.Named(),
+			self.Lex.Source.Len(),
+			"Unexpected end of file").WithKind(ErrUnexpectedEOF, "")
+	}
+	return expression, err
 }
 
 func (self Interp) NewEvalError(value Value, text string) (Value, error) {
-	return ValueNull(), NewError(self.Source.String(), value.Token.Offset, text)
+	source := self.SourceOf(value)
+	return ValueNull(), NewError(source, value.Token.Offset, text)
+}
+
+// NewEvalErrorOf creates an error of the given kind, see Error.Explain.
+func (self Interp) NewEvalErrorOf(kind ErrorKind, subject string, value Value, text string) (Value, error) {
+	source := self.SourceOf(value)
+	return ValueNull(), NewError(source, value.Token.Offset, text).
+		WithKind(kind, subject)
+}
+
+func (self *Interp) Lookup(name string) (Value, bool) {
+	for env := self.Env; env != nil; env = env.Parent {
+		if value, ok := env.Table[name]; ok {
+			return value, true
+		}
+	}
+	value, ok := self.Table[name]
+	return value, ok
+}
+
+// Bind binds the name in the innermost scope, site is the place of the name
+// in the definition, see Protect and ShadowWarning.
+func (self *Interp) Bind(name string, value Value, site Value) error {
+	if err := self.checkBind(name, site); err != nil {
+		return err
+	}
+	if self.Env != nil {
+		self.Env.Table[name] = value
+	} else {
+		self.Table[name] = value
+	}
+	return nil
 }
 
 func (self *Interp) Define(arg Value) (Value, error) {
@@ -596,15 +982,222 @@ func (self *Interp) Define(arg Value) (Value, error) {
 		if err != nil {
 			return right, err
 		}
-		self.Table[left.Symbol] = right
+		if right.Type == ValProc && right.Symbol == "" {
+			// Name anonymous procedure after the variable
+			right.Symbol = left.Symbol
+		}
+		if err := self.Bind(left.Symbol, right, left); err != nil {
+			return ValueNull(), err
+		}
 		return ValueNull(), nil
 	case ValPair:
-		return self.NewEvalError(left, fmt.Sprintf(
-			"defining functions is not supported yet"))
+		// (define (name . params) body...)
+		name := *left.PairLeft
+		if name.Type != ValSymbol {
+			return self.NewEvalError(name, fmt.Sprintf(
+				"`define` expects ValSymbol procedure name, given: %v", name))
+		}
+		proc, err := self.Lambda(name.Symbol, *left.PairRight, *arg.PairRight)
+		if err != nil {
+			return proc, err
+		}
+		if err := self.Bind(name.Symbol, proc, name); err != nil {
+			return ValueNull(), err
+		}
+		return ValueNull(), nil
 	}
 	return self.NewEvalError(left, fmt.Sprintf(
 		"`define` expects ValSymbol or ValPair argument, given: %v", left))
-	return *left.PairLeft, nil
+}
+
+// Lambda creates a procedure closed over the current lexical scope. Params is
+// either a symbol, which gets bound to the list of all the arguments, or a
+// list of symbols, possibly improper, to bind the remaining arguments to the
+// symbol at the end.
+func (self *Interp) Lambda(name string, params Value, body Value) (Value, error) {
+	var names []string
+	rest := ""
+	for list := params; list.Type != ValNull; list = *list.PairRight {
+		if list.Type == ValSymbol {
+			rest = list.Symbol
+			self.warnShadow(rest, list)
+			break
+		}
+		if list.Type != ValPair || list.PairLeft.Type != ValSymbol {
+			return self.NewEvalError(list, fmt.Sprintf(
+				"procedure parameters must be symbols, given: %v", list))
+		}
+		names = append(names, list.PairLeft.Symbol)
+		self.warnShadow(list.PairLeft.Symbol, *list.PairLeft)
+	}
+	if body.Type != ValPair {
+		return self.NewEvalError(body, fmt.Sprintf(
+			"procedure body must not be empty, given: %v", body))
+	}
+	forms, err := self.spliceIncludes(body)
+	if err != nil {
+		return ValueNull(), err
+	}
+	closure := self.Env
+	proc := func(arg Value, interp Interp) (Value, error) {
+		env := &Env{Table: map[string]Value{}, Parent: closure}
+		for _, param := range names {
+			if arg.Type != ValPair {
+				return interp.NewEvalErrorOf(ErrArity, name, body, fmt.Sprintf(
+					"`%v` expects %v arguments, given too few", name, len(names)))
+			}
+			env.Table[param] = *arg.PairLeft
+			arg = *arg.PairRight
+		}
+		if rest != "" {
+			env.Table[rest] = arg
+		} else if arg.Type != ValNull {
+			return interp.NewEvalErrorOf(ErrArity, name, body, fmt.Sprintf(
+				"`%v` expects %v arguments, given too many", name, len(names)))
+		}
+		interp.Env = env
+		return interp.Begin(forms)
+	}
+	return Value{Type: ValProc, Proc: proc, Symbol: name}, nil
+}
+
+// Begin evaluates the list of expressions in order and returns the value of
+// the last one.
+func (self *Interp) Begin(body Value) (Value, error) {
+	result := ValueNull()
+	for ; body.Type == ValPair; body = *body.PairRight {
+		value, err := self.Eval(*body.PairLeft)
+		if err != nil {
+			return value, err
+		}
+		result = value
+	}
+	return result, nil
+}
+
+// Include evaluates the expressions of the files as if they were written in
+// place of the `include` form, like `begin` does. The files are read when the
+// form is expanded: a top level form is expanded when it is evaluated, and the
+// `include` forms of a procedure body are spliced into the body when the
+// procedure is made, see spliceIncludes. Errors located in an included file
+// are reported against that file.
+func (self *Interp) Include(args Value, foldCase bool) (Value, error) {
+	forms, err := self.includedForms(args, foldCase, self.SourceName, nil)
+	if err != nil {
+		return ValueNull(), err
+	}
+	return self.Begin(NewList(forms))
+}
+
+// includedForms appends the expressions of the files named by the arguments
+// of the `include` form to forms, the `include` forms found among them are
+// replaced by the expressions of their files in turn. Relative paths are
+// resolved against the directory of the including file, from.
+func (self *Interp) includedForms(args Value, foldCase bool, from string, forms []Value) ([]Value, error) {
+	for ; args.Type == ValPair; args = *args.PairRight {
+		name := *args.PairLeft
+		if name.Type != ValString {
+			_, err := self.NewEvalError(name, fmt.Sprintf(
+				"`include` expects ValString file name, given: %v", name))
+			return forms, err
+		}
+		path := name.StringData
+		if !filepath.IsAbs(path) && from != "" && from != "<stdin>" {
+			path = filepath.Join(filepath.Dir(from), path)
+		}
+		parser, expressions, err := ParseFile(path, foldCase)
+		if _, ok := err.(*os.PathError); ok {
+			_, err := self.NewEvalError(name, fmt.Sprintf(
+				"`include` can't read file: %v", err))
+			return forms, err
+		} else if err != nil {
+			return forms, err
+		}
+		if self.Sources == nil {
+			self.Sources = Sources{}
+		}
+		self.Sources[path] = parser.Lex.Named()
+		for _, expression := range expressions {
+			if included, ok := includeForm(expression); ok {
+				forms, err = self.includedForms(*expression.PairRight, included, path, forms)
+				if err != nil {
+					return forms, err
+				}
+				continue
+			}
+			forms = append(forms, expression)
+		}
+	}
+	return forms, nil
+}
+
+// includeForm tells whether the expression is an `include` or `include-ci`
+// form, and whether it folds the case.
+func includeForm(expression Value) (foldCase bool, ok bool) {
+	if expression.Type != ValPair || expression.PairLeft.Type != ValSymbol {
+		return false, false
+	}
+	switch expression.PairLeft.Symbol {
+	case "include":
+		return false, true
+	case "include-ci":
+		return true, true
+	}
+	return false, false
+}
+
+// spliceIncludes returns the procedure body with its `include` forms replaced
+// by the expressions of the files, so that the files are read once, when the
+// procedure is made, and the definitions they hold are the definitions of the
+// body. The body is returned as it is if it has no `include` forms.
+func (self *Interp) spliceIncludes(body Value) (Value, error) {
+	var forms []Value
+	spliced := false
+	for list := body; list.Type == ValPair; list = *list.PairRight {
+		expression := *list.PairLeft
+		foldCase, ok := includeForm(expression)
+		if !ok {
+			forms = append(forms, expression)
+			continue
+		}
+		var err error
+		forms, err = self.includedForms(*expression.PairRight, foldCase, self.SourceName, forms)
+		if err != nil {
+			return body, err
+		}
+		spliced = true
+	}
+	if !spliced {
+		return body, nil
+	}
+	return NewList(forms), nil
+}
+
+// EvalFile evaluates all the expressions of the file in order and returns the
+// value of the last one. The file is parsed completely before evaluation.
+func (self Interp) EvalFile(path string, foldCase bool) (Value, error) {
+	parser, expressions, err := ParseFile(path, foldCase)
+	if err != nil {
+		return ValueNull(), err
+	}
+	self.Register(&parser.Lex)
+	result := ValueNull()
+	for _, expression := range expressions {
+		result, err = self.Eval(expression)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Apply calls the procedure with the list of already evaluated arguments.
+func (self Interp) Apply(proc Value, args Value) (Value, error) {
+	if proc.Type != ValProc {
+		return self.NewEvalErrorOf(ErrNotProcedure, Printer{}.Sprint(proc), proc, fmt.Sprintf(
+			"Wrong type to apply: %v", proc))
+	}
+	return proc.Proc(args, self)
 }
 
 func (self *Interp) EvalRight(expression Value) (Value, error) {
@@ -635,16 +1228,38 @@ func (self *Interp) EvalRight(expression Value) (Value, error) {
 }
 
 func (self *Interp) Eval(expression Value) (Value, error) {
+	if self.Limits != nil {
+		if err := self.countStep(expression); err != nil {
+			return ValueNull(), err
+		}
+	}
+	if self.Yielder != nil {
+		if err := self.yield(expression); err != nil {
+			return ValueNull(), err
+		}
+	}
+	if self.Trace != nil {
+		return self.traceEval(expression, func() (Value, error) {
+			return self.eval(expression)
+		})
+	}
+	return self.eval(expression)
+}
+
+func (self *Interp) eval(expression Value) (Value, error) {
 	switch expression.Type {
 	case ValSymbol:
-		value, ok := self.Table[expression.Symbol]
+		value, ok := self.Lookup(expression.Symbol)
 		if ok == false {
-			return self.NewEvalError(expression, fmt.Sprintf(
+			return self.NewEvalErrorOf(ErrUnboundVariable, expression.Symbol, expression, fmt.Sprintf(
 				"Unbound variable: \"%v\"", expression.Symbol))
 		}
 		return value, nil
 	case ValPair:
 		if expression.PairLeft.Type == ValSymbol {
+			if definers[expression.PairLeft.Symbol] {
+				self.RecordDefinition(expression)
+			}
 			switch expression.PairLeft.Symbol {
 			case "quote":
 				if expression.PairRight.Type != ValPair {
@@ -653,25 +1268,570 @@ func (self *Interp) Eval(expression Value) (Value, error) {
 				return *expression.PairRight.PairLeft, nil
 			case "define":
 				return self.Define(*expression.PairRight)
+			case "lambda":
+				args := *expression.PairRight
+				if args.Type != ValPair {
+					return self.NewEvalError(expression, fmt.Sprintf(
+						"`lambda` expects parameters and body, given: %v", expression))
+				}
+				return self.Lambda("", *args.PairLeft, *args.PairRight)
+			case "begin":
+				return self.Begin(*expression.PairRight)
+			case "include":
+				return self.Include(*expression.PairRight, false)
+			case "include-ci":
+				return self.Include(*expression.PairRight, true)
+			case "define-macro":
+				return self.DefineMacro(*expression.PairRight)
+			case "begin-for-syntax":
+				return self.BeginForSyntax(*expression.PairRight)
+			case "eval-when":
+				return self.EvalWhen(*expression.PairRight)
+			case ":":
+				// Type annotations are for `typecheck` subcommand only
+				return ValueNull(), nil
+			case "define/contract":
+				return self.DefineContract(*expression.PairRight)
+			case "define-memoized":
+				return self.DefineMemoized(*expression.PairRight)
+			case "destructuring-bind":
+				return self.DestructuringBind(*expression.PairRight)
 			}
 		}
 		left, err := self.Eval(*expression.PairLeft)
 		if err != nil {
 			return Value{Type: ValNull}, err
 		}
+		if left.Type == ValMacro {
+			expanded, err := self.Expand(left, expression)
+			if err != nil {
+				return expanded, err
+			}
+			return self.Eval(expanded)
+		}
 		if left.Type != ValProc {
-			return self.NewEvalError(expression, fmt.Sprintf(
+			return self.NewEvalErrorOf(ErrNotProcedure, Printer{}.Sprint(left), expression, fmt.Sprintf(
 				"Wrong type to apply: %v", expression))
 		}
+		list := *expression.PairRight
+		for list.Type == ValPair {
+			list = *list.PairRight
+		}
+		if list.Type != ValNull {
+			return self.NewEvalError(expression, fmt.Sprintf(
+				"Improper list of arguments: %v", expression))
+		}
+		if left.Fixnum != FixnumNone {
+			if result, ok, err := self.EvalFixnum(left, expression); ok {
+				return result, err
+			}
+		}
 		right, err := self.EvalRight(*expression.PairRight)
 		if err != nil {
 			return Value{Type: ValNull}, err
 		}
-		return left.Proc(right, *self)
+		caller := *self
+		caller.Call = expression
+		return left.Proc(right, caller)
 	}
 	return expression, nil
 }
 
+// NumberArgs converts the argument list into a slice of numeric values. The
+// arguments are evaluated already, so the errors are located at the call.
+func (self Interp) NumberArgs(arg Value, name string) ([]Value, error) {
+	var args []Value
+	var position int
+	for arg.Type != ValNull {
+		position++
+		if arg.Type != ValPair {
+			_, err := self.NewEvalError(self.Call, fmt.Sprintf(
+				"`%v` expects proper list, given improper list end %v", name, arg))
+			return args, err
+		}
+		left := *arg.PairLeft
+		if !IsNumber(left) {
+			_, err := self.NewEvalErrorOf(ErrWrongType, name, self.Call, fmt.Sprintf(
+				"`%v` expects number, given %v at position %v", name, arg, position))
+			return args, err
+		}
+		args = append(args, left)
+		if arg.PairRight == nil {
+			panic("ValPair.PairRight points to nil")
+		}
+		arg = *arg.PairRight
+	}
+	return args, nil
+}
+
+func plusFn(arg Value, interp Interp) (Value, error) {
+	if arg.Type == ValNull {
+		return ValueNull(), nil
+	}
+	args, err := interp.NumberArgs(arg, "+")
+	if err != nil {
+		return ValueNull(), err
+	}
+	acc := args[0]
+	for _, v := range args[1:] {
+		acc = AddNumbers(acc, v)
+	}
+	return acc, nil
+}
+
+func minusFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.NumberArgs(arg, "-")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if len(args) == 0 {
+		return interp.NewEvalError(arg, "`-` expects at least 1 argument, given none")
+	}
+	if len(args) == 1 {
+		// Single argument is negated
+		args = append([]Value{Value{Type: ValNumber, Number: 0}}, args...)
+	}
+	acc := args[0]
+	for _, v := range args[1:] {
+		acc = SubNumbers(acc, v)
+	}
+	return acc, nil
+}
+
+func timesFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.NumberArgs(arg, "*")
+	if err != nil {
+		return ValueNull(), err
+	}
+	acc := Value{Type: ValNumber, Number: 1}
+	for _, v := range args {
+		acc = MulNumbers(acc, v)
+	}
+	return acc, nil
+}
+
+// CompareReals returns -1, 0 or 1 if a is less, equal or greater than b.
+func CompareReals(a, b Value) int {
+	if a.Type == ValNumber && b.Type == ValNumber {
+		switch {
+		case a.Number < b.Number:
+			return -1
+		case a.Number > b.Number:
+			return 1
+		}
+		return 0
+	}
+	return NumberToDecimal(a).Cmp(NumberToDecimal(b))
+}
+
+func lessFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.NumberArgs(arg, "<")
+	if err != nil {
+		return ValueNull(), err
+	}
+	for _, v := range args {
+		if !IsReal(v) {
+			return interp.NewEvalErrorOf(ErrWrongType, "<", v, fmt.Sprintf(
+				"`<` expects real number, given: %v", v))
+		}
+	}
+	for i := 1; i < len(args); i++ {
+		if CompareReals(args[i-1], args[i]) >= 0 {
+			return Value{Type: ValBool, Bool: false}, nil
+		}
+	}
+	return Value{Type: ValBool, Bool: true}, nil
+}
+
+func numberEqualFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.NumberArgs(arg, "=")
+	if err != nil {
+		return ValueNull(), err
+	}
+	for i := 1; i < len(args); i++ {
+		a, b := ToComplex(args[i-1]), ToComplex(args[i])
+		if CompareReals(a.Real, b.Real) != 0 || CompareReals(a.Imag, b.Imag) != 0 {
+			return Value{Type: ValBool, Bool: false}, nil
+		}
+	}
+	return Value{Type: ValBool, Bool: true}, nil
+}
+
+func consFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "cons", 2, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	return *NewNode(&args[0], &args[1]), nil
+}
+
+func listFn(arg Value, interp Interp) (Value, error) {
+	return arg, nil
+}
+
+// typePredicate makes a procedure like `number?` telling whether the argument
+// passes the test.
+func typePredicate(name string, test func(Value) bool) Value {
+	return Value{Type: ValProc, Proc: func(arg Value, interp Interp) (Value, error) {
+		value, err := interp.SingleArg(arg, name)
+		if err != nil {
+			return ValueNull(), err
+		}
+		return Value{Type: ValBool, Bool: test(value)}, nil
+	}}
+}
+
+func isType(t ValueType) func(Value) bool {
+	return func(v Value) bool { return v.Type == t }
+}
+
+func IsList(v Value) bool {
+	// The slow one walks half of the way, so they meet if the list is cyclic
+	slow := v
+	for steps := 0; v.Type == ValPair; steps++ {
+		v = *v.PairRight
+		if steps%2 == 1 {
+			slow = *slow.PairRight
+			if v.Type == ValPair && v.PairLeft == slow.PairLeft {
+				return false
+			}
+		}
+	}
+	return v.Type == ValNull
+}
+
+// Equal compares the values structurally, like `equal?` does. Numbers are
+// equal only if they are of the same type and written the same way, so 1,
+// #d1.0 and #d1.00 are different, use `=` to compare them numerically.
+// Procedures and macros are never equal, they have no identity to compare.
+// Cyclic lists and vectors are equal if they are unfolded into the same
+// infinite trees.
+func Equal(a, b Value) bool {
+	var equality equality
+	return equality.equal(a, b)
+}
+
+// equalTrackingSteps is the number of pairs and vectors compared before the
+// compared nodes are remembered, so comparing small values allocates nothing.
+const equalTrackingSteps = 1000
+
+// equality is the state of Equal. The nodes compared again are assumed to be
+// equal, since they are already being compared: the cycles of the values make
+// no difference then, and the comparison ends.
+type equality struct {
+	steps   int
+	assumed map[[2]interface{}]bool
+}
+
+func (self *equality) equal(a, b Value) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	if identity(a) != nil {
+		if self.steps++; self.steps > equalTrackingSteps {
+			if self.assumed == nil {
+				self.assumed = map[[2]interface{}]bool{}
+			}
+			key := [2]interface{}{identity(a), identity(b)}
+			if self.assumed[key] {
+				return true
+			}
+			self.assumed[key] = true
+		}
+	}
+	switch a.Type {
+	case ValNull:
+		return true
+	case ValBool:
+		return a.Bool == b.Bool
+	case ValPair:
+		return self.equal(*a.PairLeft, *b.PairLeft) && self.equal(*a.PairRight, *b.PairRight)
+	case ValSymbol:
+		return a.Symbol == b.Symbol
+	case ValNumber:
+		return a.Number == b.Number
+	case ValChar:
+		return a.Char == b.Char
+	case ValString:
+		return a.StringData == b.StringData
+	case ValDecimal:
+		return a.Decimal.Scale == b.Decimal.Scale && a.Decimal.Cmp(b.Decimal) == 0
+	case ValComplex:
+		return Equal(a.Complex.Real, b.Complex.Real) && Equal(a.Complex.Imag, b.Complex.Imag)
+	case ValVector, ValArray:
+		if len(a.Vector.Items) != len(b.Vector.Items) || len(a.Vector.Dims) != len(b.Vector.Dims) {
+			return false
+		}
+		for i := range a.Vector.Dims {
+			if a.Vector.Dims[i] != b.Vector.Dims[i] {
+				return false
+			}
+		}
+		for i := range a.Vector.Items {
+			if !self.equal(a.Vector.Items[i], b.Vector.Items[i]) {
+				return false
+			}
+		}
+		return true
+	case ValPort:
+		return a.Port == b.Port
+	case ValStore:
+		return a.Store == b.Store
+	case ValRandom:
+		return a.Random == b.Random
+	case ValChannel:
+		return a.Channel == b.Channel
+	case ValEOF:
+		return true
+	}
+	return false
+}
+
+func equalFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "equal?", 2, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	return Value{Type: ValBool, Bool: Equal(args[0], args[1])}, nil
+}
+
+func carFn(arg Value, interp Interp) (Value, error) {
+	if arg.Type != ValPair {
+		return interp.NewEvalError(arg, fmt.Sprintf(
+			"`car` expects single list argument, given %v", arg))
+	}
+	left := *arg.PairLeft
+	if left.Type != ValPair {
+		return interp.NewEvalErrorOf(ErrWrongType, "car", left, fmt.Sprintf(
+			"`car` expects ValPair argument, given: %v", left))
+	}
+	return *left.PairLeft, nil
+}
+
+func cdrFn(arg Value, interp Interp) (Value, error) {
+	if arg.Type != ValPair {
+		return interp.NewEvalError(arg, fmt.Sprintf(
+			"`cdr` expects single list argument, given %v", arg))
+	}
+	left := *arg.PairLeft
+	if left.Type != ValPair {
+		return interp.NewEvalErrorOf(ErrWrongType, "cdr", left, fmt.Sprintf(
+			"`cdr` expects ValPair argument, given: %v", left))
+	}
+	return *left.PairRight, nil
+}
+
+func (self Interp) SingleArg(arg Value, name string) (Value, error) {
+	if arg.Type != ValPair || arg.PairRight.Type != ValNull {
+		return self.NewEvalErrorOf(ErrArity, name, arg, fmt.Sprintf(
+			"`%v` expects single argument, given %v", name, arg))
+	}
+	return *arg.PairLeft, nil
+}
+
+// MaxArgs is used as max argument of RangeArgs for procedures accepting any
+// count of arguments.
+const MaxArgs = int(^uint(0) >> 1)
+
+// RangeArgs converts the argument list into a slice, ensuring that the count
+// of arguments is between min and max inclusively.
+func (self Interp) RangeArgs(arg Value, name string, min, max int) ([]Value, error) {
+	var args []Value
+	list := arg
+	// The slow one walks half of the way, so they meet if the list is cyclic
+	slow := arg
+	for list.Type == ValPair {
+		args = append(args, *list.PairLeft)
+		list = *list.PairRight
+		if len(args)%2 == 0 {
+			slow = *slow.PairRight
+			if list.Type == ValPair && list.PairLeft == slow.PairLeft {
+				_, err := self.NewEvalError(self.Call, fmt.Sprintf(
+					"`%v` expects proper list, given cyclic list", name))
+				return args, err
+			}
+		}
+	}
+	if list.Type != ValNull {
+		_, err := self.NewEvalError(list, fmt.Sprintf(
+			"`%v` expects proper list, given improper list end %v", name, list))
+		return args, err
+	}
+	if len(args) < min || len(args) > max {
+		var expected string
+		if min == max {
+			expected = fmt.Sprint(min)
+		} else {
+			expected = fmt.Sprintf("%v to %v", min, max)
+		}
+		_, err := self.NewEvalErrorOf(ErrArity, name, arg, fmt.Sprintf(
+			"`%v` expects %v arguments, given %v", name, expected, len(args)))
+		return args, err
+	}
+	return args, nil
+}
+
+func (self Interp) SingleStringArg(arg Value, name string) (string, error) {
+	value, err := self.SingleArg(arg, name)
+	if err != nil {
+		return "", err
+	}
+	if value.Type != ValString {
+		_, err := self.NewEvalErrorOf(ErrWrongType, name, value, fmt.Sprintf(
+			"`%v` expects ValString argument, given: %v", name, value))
+		return "", err
+	}
+	return value.StringData, nil
+}
+
+func IsASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] > 0x7F {
+			return false
+		}
+	}
+	return true
+}
+
+// FoldCase performs simple case folding: a rune is folded into the lower case
+// of its upper case, so that e.g. 'ſ' and 's' fold into the same rune. The full
+// folding (like 'ß' into "ss") is not supported.
+func FoldCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		return unicode.ToLower(unicode.ToUpper(r))
+	}, s)
+}
+
+func stringFoldcaseFn(arg Value, interp Interp) (Value, error) {
+	s, err := interp.SingleStringArg(arg, "string-foldcase")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return Value{Type: ValString, StringData: FoldCase(s)}, nil
+}
+
+func stringNormalizeNfcFn(arg Value, interp Interp) (Value, error) {
+	s, err := interp.SingleStringArg(arg, "string-normalize-nfc")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if !IsASCII(s) {
+		// ASCII strings are always in NFC, anything else requires decomposition
+		// and composition tables, which are not available.
+		return interp.NewEvalError(arg, fmt.Sprintf(
+			"`string-normalize-nfc` supports ASCII strings only, given: %v", arg))
+	}
+	return Value{Type: ValString, StringData: s}, nil
+}
+
+func Builtins() map[string]Value {
+	table := map[string]Value{
+		"+":                     Value{Type: ValProc, Proc: plusFn},
+		"-":                     Value{Type: ValProc, Proc: minusFn},
+		"*":                     Value{Type: ValProc, Proc: timesFn},
+		"<":                     Value{Type: ValProc, Proc: lessFn},
+		"=":                     Value{Type: ValProc, Proc: numberEqualFn},
+		"number?":               typePredicate("number?", IsNumber),
+		"integer?":              typePredicate("integer?", isType(ValNumber)),
+		"string?":               typePredicate("string?", isType(ValString)),
+		"boolean?":              typePredicate("boolean?", isType(ValBool)),
+		"symbol?":               typePredicate("symbol?", isType(ValSymbol)),
+		"char?":                 typePredicate("char?", isType(ValChar)),
+		"pair?":                 typePredicate("pair?", isType(ValPair)),
+		"null?":                 typePredicate("null?", isType(ValNull)),
+		"list?":                 typePredicate("list?", IsList),
+		"vector?":               typePredicate("vector?", isType(ValVector)),
+		"procedure?":            typePredicate("procedure?", isType(ValProc)),
+		"equal?":                Value{Type: ValProc, Proc: equalFn},
+		"memoize":               Value{Type: ValProc, Proc: memoizeFn},
+		"identity":              Value{Type: ValProc, Proc: identityFn},
+		"const":                 Value{Type: ValProc, Proc: constFn},
+		"partial":               Value{Type: ValProc, Proc: partialFn},
+		"compose":               Value{Type: ValProc, Proc: composeFn},
+		"command-line":          Value{Type: ValProc, Proc: commandLineFn},
+		"parse-args":            Value{Type: ValProc, Proc: parseArgsFn},
+		"decimal?":              Value{Type: ValProc, Proc: decimalPredicateFn},
+		"decimal-round":         Value{Type: ValProc, Proc: decimalRoundFn},
+		"decimal/":              Value{Type: ValProc, Proc: decimalQuoFn},
+		"make-rectangular":      Value{Type: ValProc, Proc: makeRectangularFn},
+		"real-part":             Value{Type: ValProc, Proc: realPartFn},
+		"imag-part":             Value{Type: ValProc, Proc: imagPartFn},
+		"magnitude":             Value{Type: ValProc, Proc: magnitudeFn},
+		"vector":                Value{Type: ValProc, Proc: vectorFn},
+		"make-vector":           Value{Type: ValProc, Proc: makeVectorFn},
+		"vector-length":         Value{Type: ValProc, Proc: vectorLengthFn},
+		"vector-ref":            Value{Type: ValProc, Proc: vectorRefFn},
+		"vector-set!":           Value{Type: ValProc, Proc: vectorSetFn},
+		"vector->list":          Value{Type: ValProc, Proc: vectorToListFn},
+		"list->vector":          Value{Type: ValProc, Proc: listToVectorFn},
+		"vector-sum":            Value{Type: ValProc, Proc: vectorSumFn},
+		"vector-dot":            Value{Type: ValProc, Proc: vectorDotFn},
+		"vector-scale":          Value{Type: ValProc, Proc: vectorScaleFn},
+		"make-array":            Value{Type: ValProc, Proc: makeArrayFn},
+		"array-dimensions":      Value{Type: ValProc, Proc: arrayDimensionsFn},
+		"array-ref":             Value{Type: ValProc, Proc: arrayRefFn},
+		"array-set!":            Value{Type: ValProc, Proc: arraySetFn},
+		"cons":                  Value{Type: ValProc, Proc: consFn},
+		"set-car!":              Value{Type: ValProc, Proc: setCarFn},
+		"set-cdr!":              Value{Type: ValProc, Proc: setCdrFn},
+		"freeze!":               Value{Type: ValProc, Proc: freezeFn},
+		"frozen?":               Value{Type: ValProc, Proc: frozenFn},
+		"list":                  Value{Type: ValProc, Proc: listFn},
+		"car":                   Value{Type: ValProc, Proc: carFn},
+		"cdr":                   Value{Type: ValProc, Proc: cdrFn},
+		"string<?":              stringCompareFn("string<?", strings.Compare),
+		"string-natural<?":      stringCompareFn("string-natural<?", CompareNatural),
+		"sort":                  Value{Type: ValProc, Proc: sortFn},
+		"string-foldcase":       Value{Type: ValProc, Proc: stringFoldcaseFn},
+		"string-normalize-nfc":  Value{Type: ValProc, Proc: stringNormalizeNfcFn},
+		"write-dot":             Value{Type: ValProc, Proc: writeDotFn},
+		"dribble":               Value{Type: ValProc, Proc: dribbleFn},
+		"display":               Value{Type: ValProc, Proc: displayFn},
+		"write":                 Value{Type: ValProc, Proc: writeFn},
+		"newline":               Value{Type: ValProc, Proc: newlineFn},
+		"current-output-port":   Value{Type: ValProc, Proc: currentOutputPortFn},
+		"open-output-string":    Value{Type: ValProc, Proc: openOutputStringFn},
+		"get-output-string":     Value{Type: ValProc, Proc: getOutputStringFn},
+		"with-output-to-string": Value{Type: ValProc, Proc: withOutputToStringFn},
+		"open-input-file":       Value{Type: ValProc, Proc: openInputFileFn},
+		"open-input-string":     Value{Type: ValProc, Proc: openInputStringFn},
+		"close-port":            Value{Type: ValProc, Proc: closePortFn},
+		"port->sha256":          Value{Type: ValProc, Proc: portSha256Fn},
+		"make-rate-limiter":     Value{Type: ValProc, Proc: makeRateLimiterFn},
+		"http-get/retry":        Value{Type: ValProc, Proc: httpGetRetryFn},
+		"make-random-source":    Value{Type: ValProc, Proc: makeRandomSourceFn},
+		"random-integer":        Value{Type: ValProc, Proc: randomIntegerFn},
+		"random-real":           Value{Type: ValProc, Proc: randomRealFn},
+		"eof-object":            Value{Type: ValProc, Proc: eofObjectFn},
+		"eof-object?":           Value{Type: ValProc, Proc: eofObjectPredicateFn},
+		"read":                  Value{Type: ValProc, Proc: readFn},
+		"make-channel":          Value{Type: ValProc, Proc: makeChannelFn},
+		"channel?":              Value{Type: ValProc, Proc: channelPredicateFn},
+		"channel-put!":          Value{Type: ValProc, Proc: channelPutFn},
+		"channel-get":           Value{Type: ValProc, Proc: channelGetFn},
+		"close-channel":         Value{Type: ValProc, Proc: closeChannelFn},
+		"channel->port":         Value{Type: ValProc, Proc: channelPortFn},
+		"port->channel":         Value{Type: ValProc, Proc: portChannelFn},
+		"kv-open":               Value{Type: ValProc, Proc: kvOpenFn},
+		"kv-get":                Value{Type: ValProc, Proc: kvGetFn},
+		"kv-set!":               Value{Type: ValProc, Proc: kvSetFn},
+		"kv-delete!":            Value{Type: ValProc, Proc: kvDeleteFn},
+		"kv-keys":               Value{Type: ValProc, Proc: kvKeysFn},
+		"kv-close":              Value{Type: ValProc, Proc: kvCloseFn},
+	}
+	for name, value := range table {
+		value.Symbol = name
+		value.Fixnum = fixnumOps[name]
+		table[name] = value
+	}
+	// Limits of the printed results in REPL, see PrinterFromInterp
+	table["*print-level*"] = Value{Type: ValBool, Bool: false}
+	table["*print-length*"] = Value{Type: ValBool, Bool: false}
+	// Procedures of `define/contract` are not checked when set to #f
+	table["*contracts*"] = Value{Type: ValBool, Bool: true}
+	return table
+}
+
 func TestLex() {
 	var lex Lex
 	var tokens []Token
@@ -707,80 +1867,234 @@ func TestPars() {
 	}
 }
 
-func TestEval() {
-	plusFn := func(arg Value, interp Interp) (Value, error) {
-		if arg.Type == ValNull {
-			return ValueNull(), nil
-		}
-		var acc, position int
-		for arg.Type != ValNull {
-			position++
-			if arg.Type != ValPair {
-				return interp.NewEvalError(arg, fmt.Sprintf(
-					"`+` expects proper list, given improper list end %v", arg))
-			}
-			left := *arg.PairLeft
-			if left.Type != ValNumber {
-				return interp.NewEvalError(arg, fmt.Sprintf(
-					"`+` expects number, given %v at position %v", arg, position))
-			}
-			acc += left.Number
-			if arg.PairRight == nil {
-				panic("ValPair.PairRight points to nil")
-			}
-			arg = *arg.PairRight
-		}
-		return Value{Type: ValNumber, Number: acc}, nil
+// NewInterp creates an interpreter with the builtins defined. The source must
+// be set before evaluation.
+func NewInterp() Interp {
+	return Interp{
+		Table:       Builtins(),
+		Sources:     Sources{},
+		Definitions: map[string]string{},
+		Protected:   map[string]bool{},
+		FrozenPairs: map[*Value]bool{},
+		SyntaxEnv:   &Env{Table: map[string]Value{}},
 	}
-	carFn := func(arg Value, interp Interp) (Value, error) {
-		if arg.Type != ValPair {
-			return interp.NewEvalError(arg, fmt.Sprintf(
-				"`car` expects single list argument, given %v", arg))
+}
+
+type Options struct {
+	FoldCase bool
+	// Teach makes errors explained in plain language, see Error.Explain
+	Teach bool
+	// PrintLevel and PrintLength are initial values of `*print-level*` and
+	// `*print-length*`, zero means no limit.
+	PrintLevel  int
+	PrintLength int
+	// Output is the format of the results, either "text" or "json", see Record
+	Output string
+	// WarnShadow prints warnings on definitions and parameters hiding the
+	// visible bindings, see Interp.ShadowWarning
+	WarnShadow bool
+	// NoContracts disables checks of `define/contract` procedures
+	NoContracts bool
+	// TraceJSON is the file to write evaluation trace to, see Tracer
+	TraceJSON string
+	// MaxSteps and FormTimeout limit every form in batch mode, see Limits
+	MaxSteps    int64
+	FormTimeout time.Duration
+	// Protect are the top level names the program can't rebind, see Protect
+	Protect []string
+}
+
+// ConfigureInterp applies the options common for REPL and batch mode, the
+// warnings are written into the writer. The returned function must be called
+// when the interpreter is done, to flush the trace.
+func ConfigureInterp(interpreter *Interp, options Options, warnings io.Writer) func() {
+	closer := func() {}
+	if options.TraceJSON != "" {
+		file, err := os.Create(options.TraceJSON)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't create trace file: %v\n", err)
+			os.Exit(1)
 		}
-		left := *arg.PairLeft
-		if left.Type != ValPair {
-			return interp.NewEvalError(left, fmt.Sprintf(
-				"`car` expects ValPair argument, given: %v", left))
+		buffered := bufio.NewWriter(file)
+		closer = func() {
+			buffered.Flush()
+			file.Close()
 		}
-		return *left.PairLeft, nil
+		interpreter.Trace = NewTracer(buffered)
 	}
-	cdrFn := func(arg Value, interp Interp) (Value, error) {
-		if arg.Type != ValPair {
-			return interp.NewEvalError(arg, fmt.Sprintf(
-				"`cdr` expects single list argument, given %v", arg))
+	if options.WarnShadow {
+		interpreter.ShadowWarning = func(name string, warning error) {
+			fmt.Fprintf(warnings, "Warning: %v\n", warning)
 		}
-		left := *arg.PairLeft
-		if left.Type != ValPair {
-			return interp.NewEvalError(left, fmt.Sprintf(
-				"`cdr` expects ValPair argument, given: %v", left))
-		}
-		return *left.PairRight, nil
 	}
+	if options.NoContracts {
+		interpreter.Table["*contracts*"] = Value{Type: ValBool, Bool: false}
+	}
+	if options.PrintLevel > 0 {
+		interpreter.Table["*print-level*"] = Value{Type: ValNumber, Number: options.PrintLevel}
+	}
+	if options.PrintLength > 0 {
+		interpreter.Table["*print-length*"] = Value{Type: ValNumber, Number: options.PrintLength}
+	}
+	interpreter.Protect(options.Protect...)
+	return closer
+}
+
+func TestEval(options Options) {
 	var parser Pars
-	var interpreter Interp
-	interpreter.Source = &parser.Lex.Source
-	interpreter.Table = map[string]Value{
-		"+":   Value{Type: ValProc, Proc: plusFn},
-		"car": Value{Type: ValProc, Proc: carFn},
-		"cdr": Value{Type: ValProc, Proc: cdrFn},
+	parser.Lex.Name = "<stdin>"
+	parser.Lex.FoldCase = options.FoldCase
+	interpreter := NewInterp()
+	interpreter.Register(&parser.Lex)
+	interpreter.CommandLine = []string{os.Args[0]}
+	dribble := &Dribble{Source: &parser.Lex.Source, Output: os.Stdout}
+	defer dribble.Close()
+	interpreter.Dribble = dribble
+	interpreter.Output = &Port{Name: "stdout", Output: dribble}
+	closer := ConfigureInterp(&interpreter, options, dribble)
+	defer closer()
+	var records *RecordWriter
+	if options.Output == "json" {
+		records = NewRecordWriter(dribble, &interpreter, options.Teach)
 	}
+	input := bufio.NewReader(os.Stdin)
+	history := &History{}
+	interpreter.History = history
+	var results int
 	for {
-		expression, err := parser.Parse(os.Stdin, false)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			fmt.Printf("Parsing error: %s\n", err.Error())
+		if command, ok := ReadCommand(input, &parser); ok {
+			dribble.Record(parser.Lex.Source.Len())
+			if err := RunCommand(command, interpreter, dribble); err != nil {
+				fmt.Fprintf(dribble, "Command error: %s\n", err.Error())
+			}
 			continue
 		}
-		result, err := interpreter.Eval(expression)
-		if err != nil {
-			fmt.Printf("Eval error: %s\n", err.Error())
-		} else {
-			fmt.Printf("Eval result: %v\n", result)
+		// The forms pasted at once are parsed before any of them is evaluated,
+		// so the results are printed after the paste, in order
+		var forms []replForm
+		start := parser.Lex.Source.Len()
+		eof := false
+		for {
+			consumed := parser.Lex.Source.Len()
+			expression, err := parser.Parse(input, false)
+			if err == io.EOF {
+				eof = true
+				break
+			}
+			forms = append(forms, replForm{expression, parser.Lex.Source.String()[consumed:], parser.Lex.Source.Len(), err})
+			if !PasteContinues(input, &parser) {
+				break
+			}
+		}
+		history.Add(parser.Lex.Source.String()[start:])
+		for _, form := range forms {
+			dribble.Record(form.end)
+			if form.err != nil && records != nil {
+				records.Write(form.text, "", nil, form.err)
+				continue
+			} else if form.err != nil {
+				fmt.Fprintf(dribble, "Parsing error: %s\n", form.err.Error())
+				if options.Teach {
+					fmt.Fprint(dribble, ExplainError(form.err))
+				}
+				continue
+			}
+			expression := form.expression
+			result, err := interpreter.Eval(expression)
+			if err != nil && records != nil {
+				records.Write(FormText(&parser.Lex, expression), "", nil, err)
+			} else if err != nil {
+				fmt.Fprintf(dribble, "Eval error: %s\n", err.Error())
+				if options.Teach {
+					fmt.Fprint(dribble, ExplainError(err))
+				}
+			} else {
+				// Results are bound to $1, $2 and so on to refer to them later
+				results++
+				name := fmt.Sprintf("$%v", results)
+				interpreter.Table[name] = result
+				printed := PrinterFromInterp(interpreter).Sprint(result)
+				if records != nil {
+					records.Write(FormText(&parser.Lex, expression), name, &printed, nil)
+				} else {
+					fmt.Fprintf(dribble, "Eval result: %v = %v\n", name, printed)
+				}
+			}
+		}
+		if eof {
+			dribble.Record(parser.Lex.Source.Len())
+			break
 		}
 	}
 }
 
 func main() {
-	TestEval()
+	var options Options
+	flag.BoolVar(&options.FoldCase, "fold-case", false,
+		"make symbols case-insensitive, like the #!fold-case directive does")
+	flag.BoolVar(&options.Teach, "teach", false,
+		"explain errors in plain language, with hints on how to fix them")
+	flag.IntVar(&options.PrintLevel, "print-level", 0,
+		"abbreviate nested lists deeper than this in printed results, 0 for no limit")
+	flag.IntVar(&options.PrintLength, "print-length", 0,
+		"abbreviate lists longer than this in printed results, 0 for no limit")
+	flag.BoolVar(&options.WarnShadow, "warn-shadow", false,
+		"warn when a definition or a parameter hides a visible binding")
+	flag.BoolVar(&options.NoContracts, "no-contracts", false,
+		"define procedures of define/contract without checks, for performance")
+	flag.StringVar(&options.TraceJSON, "trace-json", "",
+		"write JSON object per line for every evaluation entry and exit into the `file`")
+	flag.StringVar(&options.Output, "output", "text",
+		"format of the results: text, or json for a JSON record per form")
+	flag.DurationVar(&options.FormTimeout, "form-timeout", 0,
+		"fail a top level form of the file running longer than this, like 2s")
+	flag.Func("max-steps",
+		"fail a top level form of the file taking more than `n` evaluation steps, like 1e7",
+		func(s string) error {
+			steps, err := strconv.ParseFloat(s, 64)
+			if err != nil || steps < 0 || steps != math.Trunc(steps) {
+				return fmt.Errorf("non-negative integer expected")
+			}
+			options.MaxSteps = int64(steps)
+			return nil
+		})
+	flag.Func("protect",
+		"make the comma-separated top level `names` immutable, like car,cdr",
+		func(s string) error {
+			for _, name := range strings.Split(s, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					options.Protect = append(options.Protect, name)
+				}
+			}
+			return nil
+		})
+	flag.Parse()
+	if options.Output != "text" && options.Output != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output format: %v\n", options.Output)
+		os.Exit(2)
+	}
+	args := flag.Args()
+	if len(args) > 0 {
+		switch args[0] {
+		case "check":
+			os.Exit(Check(args[1:], options))
+		case "typecheck":
+			os.Exit(Typecheck(args[1:], options))
+		case "diff":
+			os.Exit(Diff(args[1:], options))
+		case "rename":
+			os.Exit(Rename(args[1:], options))
+		case "deadcode":
+			os.Exit(Deadcode(args[1:], options))
+		case "xref":
+			os.Exit(Xref(args[1:], options))
+		case "requires":
+			os.Exit(Requires(args[1:], options))
+		case "kernel":
+			os.Exit(RunKernel(args[1:], options))
+		default:
+			os.Exit(RunBatch(args[0], args[1:], options))
+		}
+	}
+	TestEval(options)
 }
@@ -0,0 +1,78 @@
+package main
+
+import "fmt"
+
+type memoEntry struct {
+	args   Value
+	result Value
+}
+
+// Memo is the cache of a memoized procedure. Entries are keyed by the written
+// representation of the argument list, which is the same for equal? lists,
+// and the entries sharing the key are told apart by Equal.
+type Memo map[string][]memoEntry
+
+func (self Memo) Get(args Value) (Value, bool) {
+	for _, entry := range self[Printer{}.Sprint(args)] {
+		if Equal(entry.args, args) {
+			return entry.result, true
+		}
+	}
+	return ValueNull(), false
+}
+
+func (self Memo) Put(args Value, result Value) {
+	key := Printer{}.Sprint(args)
+	self[key] = append(self[key], memoEntry{args, result})
+}
+
+// Memoize returns the procedure calling proc only for the arguments it has not
+// been called with before, the results of the previous calls are reused
+// otherwise. Failed calls are not cached.
+func Memoize(proc Value) Value {
+	memo := Memo{}
+	memoized := proc
+	memoized.Proc = func(arg Value, interp Interp) (Value, error) {
+		if result, ok := memo.Get(arg); ok {
+			return result, nil
+		}
+		result, err := proc.Proc(arg, interp)
+		if err != nil {
+			return result, err
+		}
+		memo.Put(arg, result)
+		return result, nil
+	}
+	return memoized
+}
+
+func memoizeFn(arg Value, interp Interp) (Value, error) {
+	proc, err := interp.SingleArg(arg, "memoize")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if proc.Type != ValProc {
+		return interp.NewEvalErrorOf(ErrWrongType, "memoize", proc, fmt.Sprintf(
+			"`memoize` expects ValProc argument, given: %v", proc))
+	}
+	return Memoize(proc), nil
+}
+
+// DefineMemoized defines the procedure like `define` does, but memoized, so
+// the recursive calls are memoized too.
+func (self *Interp) DefineMemoized(arg Value) (Value, error) {
+	if arg.Type != ValPair || arg.PairLeft.Type != ValPair || arg.PairLeft.PairLeft.Type != ValSymbol {
+		return self.NewEvalError(arg, fmt.Sprintf(
+			"`define-memoized` expects (name . params) and body, given: %v", arg))
+	}
+	signature := *arg.PairLeft
+	name := signature.PairLeft.Symbol
+	proc, err := self.Lambda(name, *signature.PairRight, *arg.PairRight)
+	if err != nil {
+		return proc, err
+	}
+	if err := self.Bind(name, Memoize(proc), *signature.PairLeft); err != nil {
+		return ValueNull(), err
+	}
+	return ValueNull(), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Record is the outcome of a form in `--output json` mode, written as a line of
+// JSON. Value is set on success and Error on failure, Output is everything the
+// form has printed.
+type Record struct {
+	Input  string       `json:"input"`
+	Value  *string      `json:"value,omitempty"`
+	Name   string       `json:"name,omitempty"`
+	Output string       `json:"output,omitempty"`
+	Error  *ErrorRecord `json:"error,omitempty"`
+}
+
+type ErrorRecord struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	// Explanation is set in --teach mode, see Error.Explain
+	Explanation string `json:"explanation,omitempty"`
+}
+
+var errorKindNames = map[ErrorKind]string{
+	ErrGeneric:          "generic",
+	ErrUnexpectedByte:   "unexpected-byte",
+	ErrUnexpectedToken:  "unexpected-token",
+	ErrUnexpectedRparen: "unexpected-rparen",
+	ErrUnexpectedEOF:    "unexpected-eof",
+	ErrUnboundVariable:  "unbound-variable",
+	ErrNotProcedure:     "not-procedure",
+	ErrArity:            "arity",
+	ErrWrongType:        "wrong-type",
+	ErrContract:         "contract",
+	ErrProtected:        "protected",
+	ErrLimit:            "limit",
+	ErrCanceled:         "canceled",
+	ErrFrozen:           "frozen",
+}
+
+func (k ErrorKind) String() string {
+	return errorKindNames[k]
+}
+
+func NewErrorRecord(err error, teach bool) *ErrorRecord {
+	var e Error
+	if !errors.As(err, &e) {
+		return &ErrorRecord{Kind: ErrGeneric.String(), Message: err.Error()}
+	}
+	record := &ErrorRecord{
+		File:    e.FileName,
+		Line:    e.LineNumber,
+		Column:  e.OffsetInLine,
+		Kind:    e.Kind.String(),
+		Message: e.Text,
+	}
+	if teach {
+		record.Explanation = e.Explain()
+	}
+	return record
+}
+
+// FormText returns the source text of the form read by the lexer.
+func FormText(lex *Lex, form Value) string {
+	start, end := Span(form)
+	text := lex.Source.String()
+	if form.Token.Source != lex.Named() || start < 0 || end > len(text) {
+		return Printer{}.Sprint(form)
+	}
+	return text[start:end]
+}
+
+// RecordWriter writes the records, capturing the output of the forms to put
+// it into the records.
+type RecordWriter struct {
+	encoder *json.Encoder
+	capture *Port
+	teach   bool
+}
+
+// NewRecordWriter makes the interpreter print into the capture port.
+func NewRecordWriter(output io.Writer, interp *Interp, teach bool) *RecordWriter {
+	writer := &RecordWriter{json.NewEncoder(output), NewStringPort(), teach}
+	interp.Output = writer.capture
+	return writer
+}
+
+// Write writes the record of the form, value is the printed result, name is
+// the variable the result is bound to.
+func (self *RecordWriter) Write(input string, name string, value *string, err error) error {
+	record := Record{Input: strings.TrimSpace(input), Name: name, Value: value}
+	record.Output = self.capture.Buffer.String()
+	self.capture.Buffer.Reset()
+	if err != nil {
+		record.Error = NewErrorRecord(err, self.teach)
+	}
+	return self.encoder.Encode(record)
+}
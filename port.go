@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type Port struct {
+	Name   string
+	Output io.Writer
+	// Buffer is set for string ports, it accumulates everything written
+	Buffer *strings.Builder
+	// Input is set for input ports instead of Output
+	Input io.Reader
+	// Closer is set for the ports of files, see `close-port`
+	Closer io.Closer
+	// parser keeps the state of `read` between the calls
+	parser *Pars
+}
+
+var StdoutPort = &Port{Name: "stdout", Output: os.Stdout}
+
+func NewStringPort() *Port {
+	var buffer strings.Builder
+	return &Port{Name: "string", Output: &buffer, Buffer: &buffer}
+}
+
+func (self Interp) OutputPort() *Port {
+	if self.Output == nil {
+		return StdoutPort
+	}
+	return self.Output
+}
+
+// PrintArgs parses `obj [port]` arguments of `display` and `write`.
+func (self Interp) printArgs(arg Value, name string) (Value, *Port, error) {
+	args, err := self.RangeArgs(arg, name, 1, 2)
+	if err != nil {
+		return ValueNull(), nil, err
+	}
+	if len(args) == 1 {
+		return args[0], self.OutputPort(), nil
+	}
+	port, err := self.OutputPortArg(args[1], name)
+	return args[0], port, err
+}
+
+func (self Interp) OutputPortArg(v Value, name string) (*Port, error) {
+	if v.Type != ValPort || v.Port.Output == nil {
+		_, err := self.NewEvalErrorOf(ErrWrongType, name, v, fmt.Sprintf(
+			"`%v` expects output port, given: %v", name, v))
+		return nil, err
+	}
+	return v.Port, nil
+}
+
+func (self Interp) WritePort(port *Port, text string) (Value, error) {
+	if _, err := io.WriteString(port.Output, text); err != nil {
+		return self.NewEvalError(ValueNull(), fmt.Sprintf(
+			"writing to port %v failed: %v", port.Name, err))
+	}
+	return ValueNull(), nil
+}
+
+func displayFn(arg Value, interp Interp) (Value, error) {
+	value, port, err := interp.printArgs(arg, "display")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return interp.WritePort(port, Printer{Display: true}.Sprint(value))
+}
+
+func writeFn(arg Value, interp Interp) (Value, error) {
+	value, port, err := interp.printArgs(arg, "write")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return interp.WritePort(port, Printer{}.Sprint(value))
+}
+
+func newlineFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "newline", 0, 1)
+	if err != nil {
+		return ValueNull(), err
+	}
+	port := interp.OutputPort()
+	if len(args) == 1 {
+		port, err = interp.OutputPortArg(args[0], "newline")
+		if err != nil {
+			return ValueNull(), err
+		}
+	}
+	return interp.WritePort(port, "\n")
+}
+
+func currentOutputPortFn(arg Value, interp Interp) (Value, error) {
+	if _, err := interp.RangeArgs(arg, "current-output-port", 0, 0); err != nil {
+		return ValueNull(), err
+	}
+	return Value{Type: ValPort, Port: interp.OutputPort()}, nil
+}
+
+func openOutputStringFn(arg Value, interp Interp) (Value, error) {
+	if _, err := interp.RangeArgs(arg, "open-output-string", 0, 0); err != nil {
+		return ValueNull(), err
+	}
+	return Value{Type: ValPort, Port: NewStringPort()}, nil
+}
+
+func getOutputStringFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "get-output-string")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if value.Type != ValPort || value.Port.Buffer == nil {
+		return interp.NewEvalError(value, fmt.Sprintf(
+			"`get-output-string` expects string port, given: %v", value))
+	}
+	return Value{Type: ValString, StringData: value.Port.Buffer.String()}, nil
+}
+
+func withOutputToStringFn(arg Value, interp Interp) (Value, error) {
+	thunk, err := interp.SingleArg(arg, "with-output-to-string")
+	if err != nil {
+		return ValueNull(), err
+	}
+	port := NewStringPort()
+	// The interpreter is passed by value, so the current output port is
+	// restored as soon as the thunk returns, even if it fails.
+	interp.Output = port
+	if _, err := interp.Apply(thunk, ValueNull()); err != nil {
+		return ValueNull(), err
+	}
+	return Value{Type: ValString, StringData: port.Buffer.String()}, nil
+}
+
+func (self Interp) InputPortArg(v Value, name string) (*Port, error) {
+	if v.Type != ValPort || v.Port.Input == nil {
+		_, err := self.NewEvalErrorOf(ErrWrongType, name, v, fmt.Sprintf(
+			"`%v` expects input port, given: %v", name, v))
+		return nil, err
+	}
+	return v.Port, nil
+}
+
+func openInputFileFn(arg Value, interp Interp) (Value, error) {
+	path, err := interp.SingleArg(arg, "open-input-file")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if path.Type != ValString {
+		return interp.NewEvalErrorOf(ErrWrongType, "open-input-file", path, fmt.Sprintf(
+			"`open-input-file` expects ValString file name, given: %v", path))
+	}
+	file, err := os.Open(path.StringData)
+	if err != nil {
+		return interp.NewEvalError(path, fmt.Sprintf(
+			"`open-input-file` can't open file: %v", err))
+	}
+	return Value{Type: ValPort, Port: &Port{Name: path.StringData, Input: bufio.NewReader(file), Closer: file}}, nil
+}
+
+func openInputStringFn(arg Value, interp Interp) (Value, error) {
+	text, err := interp.SingleArg(arg, "open-input-string")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if text.Type != ValString {
+		return interp.NewEvalErrorOf(ErrWrongType, "open-input-string", text, fmt.Sprintf(
+			"`open-input-string` expects ValString, given: %v", text))
+	}
+	return Value{Type: ValPort, Port: &Port{Name: "string", Input: strings.NewReader(text.StringData)}}, nil
+}
+
+// closePortFn closes the file of the port, closing other ports does nothing.
+func closePortFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "close-port")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if value.Type != ValPort {
+		return interp.NewEvalErrorOf(ErrWrongType, "close-port", value, fmt.Sprintf(
+			"`close-port` expects port, given: %v", value))
+	}
+	if value.Port.Closer == nil {
+		return ValueNull(), nil
+	}
+	if err := value.Port.Closer.Close(); err != nil {
+		return interp.NewEvalError(value, fmt.Sprintf(
+			"closing port %v failed: %v", value.Port.Name, err))
+	}
+	return ValueNull(), nil
+}
+
+// portSha256Fn reads the rest of the input port and returns SHA-256 digest of
+// it as a hex string. The input is hashed as it is read, so the size of it
+// does not matter.
+func portSha256Fn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "port->sha256")
+	if err != nil {
+		return ValueNull(), err
+	}
+	port, err := interp.InputPortArg(value, "port->sha256")
+	if err != nil {
+		return ValueNull(), err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, port.Input); err != nil {
+		return interp.NewEvalError(value, fmt.Sprintf(
+			"reading from port %v failed: %v", port.Name, err))
+	}
+	return Value{Type: ValString, StringData: hex.EncodeToString(hash.Sum(nil))}, nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Printer formats values in the Lisp syntax, as opposed to Value.String(),
+// which is meant for debugging. With Display set strings and characters are
+// printed as is, otherwise they are printed the way the reader accepts them.
+type Printer struct {
+	Display bool
+	// Level limits the depth of nested lists and vectors, the deeper ones are
+	// printed as "#". Zero means no limit.
+	Level int
+	// Length limits the count of printed elements of lists and vectors, the
+	// rest is abbreviated as "...". Zero means no limit.
+	Length int
+	// labels are the datum labels of the value being printed, see Print
+	labels *datumLabels
+}
+
+// identity returns what tells the pairs and the vectors apart: the copies of
+// a pair share its car, and the copies of a vector share its Vector. It is
+// nil for the other values, which can't be part of a cycle.
+func identity(v Value) interface{} {
+	switch v.Type {
+	case ValPair:
+		return v.PairLeft
+	case ValVector, ValArray:
+		return v.Vector
+	}
+	return nil
+}
+
+// datumLabels are the pairs and the vectors that are part of cycles, and the
+// numbers of the labels printed for them so far.
+type datumLabels struct {
+	cyclic  map[interface{}]bool
+	numbers map[interface{}]int
+}
+
+const (
+	walkOnPath = iota + 1
+	walkDone
+)
+
+// findCycles walks the value depth first, the nodes reached again while they
+// are still on the path are the cyclic ones. The cdr of a pair is followed in
+// a loop, so long lists don't make the recursion deep.
+func findCycles(v Value, state map[interface{}]int, cyclic map[interface{}]bool) {
+	var spine []interface{}
+	for {
+		id := identity(v)
+		if id == nil || state[id] == walkDone {
+			break
+		}
+		if state[id] == walkOnPath {
+			cyclic[id] = true
+			break
+		}
+		state[id] = walkOnPath
+		spine = append(spine, id)
+		if v.Type != ValPair {
+			for _, item := range v.Vector.Items {
+				findCycles(item, state, cyclic)
+			}
+			break
+		}
+		findCycles(*v.PairLeft, state, cyclic)
+		v = *v.PairRight
+	}
+	for _, id := range spine {
+		state[id] = walkDone
+	}
+}
+
+// labeled tells whether the node is printed with a datum label.
+func (p Printer) labeled(v Value) bool {
+	return p.labels != nil && p.labels.cyclic[identity(v)]
+}
+
+// label prints the datum label of the node, it tells whether the node has
+// been printed already, so `#n#` reference is enough.
+func (p Printer) label(sb *strings.Builder, v Value) bool {
+	id := identity(v)
+	if number, ok := p.labels.numbers[id]; ok {
+		sb.WriteString("#" + strconv.Itoa(number) + "#")
+		return true
+	}
+	number := len(p.labels.numbers)
+	p.labels.numbers[id] = number
+	sb.WriteString("#" + strconv.Itoa(number) + "=")
+	return false
+}
+
+// PrinterFromInterp creates a printer with the limits taken from
+// `*print-level*` and `*print-length*` variables, which are either #f for no
+// limit, or a positive number.
+func PrinterFromInterp(interp Interp) Printer {
+	var printer Printer
+	if level, ok := interp.Lookup("*print-level*"); ok && level.Type == ValNumber {
+		printer.Level = level.Number
+	}
+	if length, ok := interp.Lookup("*print-length*"); ok && length.Type == ValNumber {
+		printer.Length = length.Number
+	}
+	return printer
+}
+
+func (p Printer) Sprint(v Value) string {
+	var sb strings.Builder
+	p.Print(&sb, v)
+	return sb.String()
+}
+
+// Print prints the value, the cycles made by `set-cdr!` and the like are
+// printed with datum labels the way `write` of R7RS does: the node repeated
+// is labeled as #0=(1 2 . #0#), so the printing ends.
+func (p Printer) Print(sb *strings.Builder, v Value) {
+	if identity(v) != nil {
+		cyclic := map[interface{}]bool{}
+		findCycles(v, map[interface{}]int{}, cyclic)
+		if len(cyclic) > 0 {
+			p.labels = &datumLabels{cyclic: cyclic, numbers: map[interface{}]int{}}
+		}
+	}
+	p.print(sb, v, 0)
+}
+
+// limited tells whether the count of elements printed so far reached the length
+// limit, and prints the abbreviation if so.
+func (p Printer) limited(sb *strings.Builder, count int) bool {
+	if p.Length > 0 && count >= p.Length {
+		sb.WriteString("...")
+		return true
+	}
+	return false
+}
+
+func (p Printer) print(sb *strings.Builder, v Value, depth int) {
+	if p.Level > 0 && depth >= p.Level {
+		switch v.Type {
+		case ValPair, ValVector, ValArray:
+			sb.WriteString("#")
+			return
+		}
+	}
+	if p.labeled(v) && p.label(sb, v) {
+		return
+	}
+	switch v.Type {
+	case ValNull:
+		sb.WriteString("()")
+	case ValBool:
+		if v.Bool {
+			sb.WriteString("#t")
+		} else {
+			sb.WriteString("#f")
+		}
+	case ValPair:
+		sb.WriteString("(")
+		if p.limited(sb, 0) {
+			sb.WriteString(")")
+			return
+		}
+		p.print(sb, *v.PairLeft, depth+1)
+		count := 1
+		for v = *v.PairRight; v.Type == ValPair && !p.labeled(v); v = *v.PairRight {
+			sb.WriteString(" ")
+			if p.limited(sb, count) {
+				sb.WriteString(")")
+				return
+			}
+			p.print(sb, *v.PairLeft, depth+1)
+			count++
+		}
+		if v.Type != ValNull {
+			sb.WriteString(" . ")
+			p.print(sb, v, depth+1)
+		}
+		sb.WriteString(")")
+	case ValSymbol:
+		sb.WriteString(v.Symbol)
+	case ValNumber:
+		sb.WriteString(strconv.Itoa(v.Number))
+	case ValChar:
+		if !p.Display {
+			sb.WriteString("#\\")
+		}
+		sb.WriteByte(v.Char)
+	case ValString:
+		if p.Display {
+			sb.WriteString(v.StringData)
+		} else {
+			sb.WriteString(strconv.Quote(v.StringData))
+		}
+	case ValProc:
+		sb.WriteString("#<procedure ")
+		sb.WriteString(v.Symbol)
+		sb.WriteString(">")
+	case ValDecimal:
+		sb.WriteString("#d")
+		sb.WriteString(v.Decimal.String())
+	case ValComplex:
+		sb.WriteString(v.Complex.String())
+	case ValVector:
+		sb.WriteString("#")
+		p.printItems(sb, v.Vector.Items, depth)
+	case ValArray:
+		sb.WriteString("#")
+		sb.WriteString(strconv.Itoa(len(v.Vector.Dims)))
+		sb.WriteString("a")
+		p.printArray(sb, v.Vector.Dims, v.Vector.Items, depth)
+	case ValMacro:
+		sb.WriteString("#<macro ")
+		sb.WriteString(v.Symbol)
+		sb.WriteString(">")
+	case ValPort:
+		sb.WriteString("#<port ")
+		sb.WriteString(v.Port.Name)
+		sb.WriteString(">")
+	case ValStore:
+		sb.WriteString("#<store ")
+		sb.WriteString(v.Store.Path)
+		sb.WriteString(">")
+	case ValRandom:
+		sb.WriteString("#<random-source>")
+	case ValChannel:
+		sb.WriteString("#<channel>")
+	case ValEOF:
+		sb.WriteString("#<eof>")
+	default:
+		panic("Unknown Value type " + v.Type.String())
+	}
+}
+
+func (p Printer) printItems(sb *strings.Builder, items []Value, depth int) {
+	sb.WriteString("(")
+	for i, item := range items {
+		if i != 0 {
+			sb.WriteString(" ")
+		}
+		if p.limited(sb, i) {
+			break
+		}
+		p.print(sb, item, depth+1)
+	}
+	sb.WriteString(")")
+}
+
+// printArray prints row-major items of an array as nested lists.
+func (p Printer) printArray(sb *strings.Builder, dims []int, items []Value, depth int) {
+	if p.Level > 0 && depth >= p.Level {
+		sb.WriteString("#")
+		return
+	}
+	if len(dims) == 1 {
+		p.printItems(sb, items, depth)
+		return
+	}
+	sb.WriteString("(")
+	step := len(items)
+	if dims[0] != 0 {
+		step /= dims[0]
+	}
+	for i := 0; i < dims[0]; i++ {
+		if i != 0 {
+			sb.WriteString(" ")
+		}
+		if p.limited(sb, i) {
+			break
+		}
+		p.printArray(sb, dims[1:], items[i*step:(i+1)*step], depth+1)
+	}
+	sb.WriteString(")")
+}
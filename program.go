@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FindSourceFiles returns the .scm files found in the directories and the
+// files given explicitly, sorted by name.
+func FindSourceFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && (file == path || strings.HasSuffix(file, ".scm")) {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ProgramFile is a parsed source file of a program, see LoadProgram.
+type ProgramFile struct {
+	Path        string
+	Parser      *Pars
+	Expressions []Value
+	// Includes are the paths of the files included by this one, resolved the
+	// way `include` resolves them
+	Includes []string
+}
+
+// includes returns the file names of `include` and `include-ci` forms found
+// anywhere in the expression.
+func includes(expression Value, names []string) []string {
+	if expression.Type != ValPair {
+		return names
+	}
+	if head := *expression.PairLeft; head.Type == ValSymbol {
+		switch head.Symbol {
+		case "quote":
+			return names
+		case "include", "include-ci":
+			for args := *expression.PairRight; args.Type == ValPair; args = *args.PairRight {
+				if args.PairLeft.Type == ValString {
+					names = append(names, args.PairLeft.StringData)
+				}
+			}
+			return names
+		}
+	}
+	for ; expression.Type == ValPair; expression = *expression.PairRight {
+		names = includes(*expression.PairLeft, names)
+	}
+	return names
+}
+
+// LoadProgram parses the .scm files of the paths like FindSourceFiles does and
+// the files they include, transitively. Every file is parsed once, even if it
+// is included many times. The files found in the paths go first.
+func LoadProgram(paths []string, foldCase bool) ([]ProgramFile, error) {
+	queue, err := FindSourceFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	var program []ProgramFile
+	seen := map[string]bool{}
+	for len(queue) > 0 {
+		path := filepath.Clean(queue[0])
+		queue = queue[1:]
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		parser, expressions, err := ParseFile(path, foldCase)
+		if err != nil {
+			return nil, err
+		}
+		file := ProgramFile{Path: path, Parser: parser, Expressions: expressions}
+		for _, expression := range expressions {
+			for _, name := range includes(expression, nil) {
+				if !filepath.IsAbs(name) {
+					name = filepath.Join(filepath.Dir(path), name)
+				}
+				file.Includes = append(file.Includes, name)
+			}
+		}
+		queue = append(queue, file.Includes...)
+		program = append(program, file)
+	}
+	return program, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import "fmt"
+
+// Protect makes the top level bindings immutable, so the program can't
+// redefine them, e.g. to keep builtins the Go code relies on intact. The
+// --protect flag protects the names it is given.
+func (self *Interp) Protect(names ...string) {
+	if self.Protected == nil {
+		self.Protected = map[string]bool{}
+	}
+	for _, name := range names {
+		self.Protected[name] = true
+	}
+}
+
+// checkBind fails if the binding of the name at the current scope would
+// replace a protected binding and reports the binding hiding or replacing a
+// visible one with ShadowWarning. Site is the place of the binding name.
+func (self *Interp) checkBind(name string, site Value) error {
+	if self.Env == nil && self.Protected[name] {
+		_, err := self.NewEvalErrorOf(ErrProtected, name, site, fmt.Sprintf(
+			"Can't rebind protected `%v`", name))
+		return err
+	}
+	if self.Env != nil {
+		if _, ok := self.Env.Table[name]; ok {
+			// Rebinding in the same scope hides nothing
+			return nil
+		}
+	}
+	self.warnShadow(name, site)
+	return nil
+}
+
+// warnShadow calls ShadowWarning if the name is bound already.
+func (self *Interp) warnShadow(name string, site Value) {
+	if self.ShadowWarning == nil {
+		return
+	}
+	if _, ok := self.Lookup(name); ok {
+		_, warning := self.NewEvalError(site, fmt.Sprintf(
+			"`%v` shadows existing binding", name))
+		self.ShadowWarning(name, warning)
+	}
+}
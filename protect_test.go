@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// evalSource evaluates the expressions of the source in order and returns the
+// error of the first failing one.
+func evalSource(interp *Interp, source string) error {
+	var parser Pars
+	parser.Lex.Name = "test"
+	expressions, err := parser.ParseAll(strings.NewReader(source))
+	if err != nil {
+		return err
+	}
+	interp.Register(&parser.Lex)
+	for _, expression := range expressions {
+		if _, err := interp.Eval(expression); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestProtectedRebinding(t *testing.T) {
+	interp := NewInterp()
+	if err := evalSource(&interp, "(define answer 42)"); err != nil {
+		t.Fatal(err)
+	}
+	closer := ConfigureInterp(&interp, Options{Protect: []string{"car", "answer"}}, io.Discard)
+	defer closer()
+	for _, source := range []string{
+		"(define car cdr)",
+		"(define (car x) x)",
+		"(define answer 43)",
+		"(define-memoized (answer) 43)",
+	} {
+		err := evalSource(&interp, source)
+		e, ok := err.(Error)
+		if !ok || e.Kind != ErrProtected {
+			t.Errorf("%v: expected protected error, got %v", source, err)
+			continue
+		}
+		name := e.Subject
+		if !strings.Contains(e.Text, "`"+name+"`") || !strings.Contains(source, name) {
+			t.Errorf("%v: error doesn't name the binding: %v", source, e.Text)
+		}
+	}
+	if answer := interp.Table["answer"]; answer.Number != 42 {
+		t.Errorf("protected binding changed: %v", answer)
+	}
+	if err := evalSource(&interp, "(define cdr car) ((lambda (car) car) 1)"); err != nil {
+		t.Errorf("unprotected and local bindings must be allowed: %v", err)
+	}
+}
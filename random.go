@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync"
+)
+
+// RandomSource is a pseudo-random generator of its own, so that the programs
+// using different sources don't affect each other and the same seed gives
+// the same sequence every time.
+type RandomSource struct {
+	mutex  sync.Mutex
+	random *rand.Rand
+}
+
+// randomRealScale is the number of decimal digits of `random-real` results.
+const randomRealScale = 16
+
+func NewRandomSource(seed int64) *RandomSource {
+	return &RandomSource{random: rand.New(rand.NewSource(seed))}
+}
+
+// Int63n returns an integer in [0, n), n must be positive.
+func (self *RandomSource) Int63n(n int64) int64 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.random.Int63n(n)
+}
+
+// Real returns a decimal in the open interval (0, 1).
+func (self *RandomSource) Real() Decimal {
+	denominator := pow10(randomRealScale).Int64()
+	unscaled := 1 + self.Int63n(denominator-1)
+	return Decimal{big.NewInt(unscaled), randomRealScale}
+}
+
+func (self Interp) randomSourceArg(v Value, name string) (*RandomSource, error) {
+	if v.Type != ValRandom {
+		_, err := self.NewEvalErrorOf(ErrWrongType, name, v, fmt.Sprintf(
+			"`%v` expects random source, given: %v", name, v))
+		return nil, err
+	}
+	return v.Random, nil
+}
+
+func makeRandomSourceFn(arg Value, interp Interp) (Value, error) {
+	seed, err := interp.SingleArg(arg, "make-random-source")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if seed.Type != ValNumber {
+		return interp.NewEvalErrorOf(ErrWrongType, "make-random-source", seed, fmt.Sprintf(
+			"`make-random-source` expects integer seed, given: %v", seed))
+	}
+	return Value{Type: ValRandom, Random: NewRandomSource(int64(seed.Number))}, nil
+}
+
+// randomIntegerFn handles `(random-integer source n)`, which returns an
+// integer in [0, n).
+func randomIntegerFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "random-integer", 2, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	source, err := interp.randomSourceArg(args[0], "random-integer")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if args[1].Type != ValNumber || args[1].Number <= 0 {
+		return interp.NewEvalErrorOf(ErrWrongType, "random-integer", args[1], fmt.Sprintf(
+			"`random-integer` expects positive integer, given: %v", args[1]))
+	}
+	return Value{Type: ValNumber, Number: int(source.Int63n(int64(args[1].Number)))}, nil
+}
+
+// randomRealFn handles `(random-real source)`, which returns a decimal in
+// (0, 1) with 16 digits after the point, since there are no floating point
+// numbers.
+func randomRealFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "random-real")
+	if err != nil {
+		return ValueNull(), err
+	}
+	source, err := interp.randomSourceArg(value, "random-real")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return Value{Type: ValDecimal, Decimal: source.Real()}, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+type ReferenceKind int
+
+const (
+	RefUse ReferenceKind = iota
+	RefDefinition
+	// RefAnnotation is the name of `(: name type)`
+	RefAnnotation
+)
+
+// Reference is an occurrence of a top level name in the program, as opposed
+// to the names bound by procedure parameters and internal definitions.
+type Reference struct {
+	Kind ReferenceKind
+	// Symbol is the symbol as parsed, located in its source
+	Symbol Value
+	// Scope are the local names visible at the reference, which hide the top
+	// level names of the same spelling
+	Scope map[string]bool
+}
+
+// referenceWalker finds the references the way the evaluator would resolve
+// the names, but without evaluating anything. The uses of macros are treated
+// like calls, since their expansion is not known without evaluation.
+type referenceWalker struct {
+	references []Reference
+}
+
+// TopLevelReferences returns the references of the top level names in the
+// expressions, in the order of the source.
+func TopLevelReferences(expressions []Value) []Reference {
+	var walker referenceWalker
+	for _, expression := range expressions {
+		walker.walk(expression, nil)
+	}
+	return walker.references
+}
+
+func (self *referenceWalker) add(kind ReferenceKind, symbol Value, shadowed map[string]bool) {
+	if symbol.Type == ValSymbol && !shadowed[symbol.Symbol] {
+		self.references = append(self.references, Reference{kind, symbol, shadowed})
+	}
+}
+
+// body walks the body of a procedure, where internal definitions hide the top
+// level names in the whole body.
+func (self *referenceWalker) body(list Value, shadowed map[string]bool) {
+	var inner map[string]bool
+	for forms := list; forms.Type == ValPair; forms = *forms.PairRight {
+		form := *forms.PairLeft
+		if form.Type != ValPair || form.PairLeft.Type != ValSymbol ||
+			!definers[form.PairLeft.Symbol] || shadowed[form.PairLeft.Symbol] {
+			continue
+		}
+		if name := listName(form); name.Type == ValSymbol {
+			if inner == nil {
+				inner, _, _ = params(ValueNull(), shadowed)
+			}
+			inner[name.Symbol] = true
+		}
+	}
+	if inner == nil {
+		inner = shadowed
+	}
+	self.sequence(list, inner)
+}
+
+func (self *referenceWalker) sequence(list Value, shadowed map[string]bool) {
+	for ; list.Type == ValPair; list = *list.PairRight {
+		self.walk(*list.PairLeft, shadowed)
+	}
+	self.walk(list, shadowed)
+}
+
+// procedure walks `(name . params) body...` of a definition.
+func (self *referenceWalker) procedure(signature Value, body Value, shadowed map[string]bool) {
+	self.add(RefDefinition, *signature.PairLeft, shadowed)
+	inner, _, _ := params(*signature.PairRight, shadowed)
+	self.body(body, inner)
+}
+
+func (self *referenceWalker) walk(expression Value, shadowed map[string]bool) {
+	if expression.Type == ValSymbol {
+		self.add(RefUse, expression, shadowed)
+		return
+	}
+	if expression.Type != ValPair {
+		return
+	}
+	head := *expression.PairLeft
+	rest := *expression.PairRight
+	if head.Type != ValSymbol || shadowed[head.Symbol] {
+		self.sequence(expression, shadowed)
+		return
+	}
+	switch head.Symbol {
+	case "quote", "include", "include-ci":
+		return
+	case "lambda":
+		if rest.Type == ValPair {
+			inner, _, _ := params(*rest.PairLeft, shadowed)
+			self.body(*rest.PairRight, inner)
+		}
+		return
+	case "define", "define-memoized", "define-macro":
+		if rest.Type != ValPair {
+			break
+		}
+		if target := *rest.PairLeft; target.Type == ValPair {
+			self.procedure(target, *rest.PairRight, shadowed)
+			return
+		}
+		self.add(RefDefinition, *rest.PairLeft, shadowed)
+		self.sequence(*rest.PairRight, shadowed)
+		return
+	case "define/contract":
+		if rest.Type != ValPair || rest.PairLeft.Type != ValPair || rest.PairRight.Type != ValPair {
+			break
+		}
+		self.walk(*rest.PairRight.PairLeft, shadowed)
+		self.procedure(*rest.PairLeft, *rest.PairRight.PairRight, shadowed)
+		return
+	case ":":
+		if rest.Type == ValPair {
+			self.add(RefAnnotation, *rest.PairLeft, shadowed)
+		}
+		return
+	case "destructuring-bind":
+		if rest.Type == ValPair && rest.PairRight.Type == ValPair {
+			self.walk(*rest.PairRight.PairLeft, shadowed)
+			self.body(*rest.PairRight.PairRight, bindPattern(*rest.PairLeft, shadowed))
+		}
+		return
+	case "eval-when":
+		if rest.Type == ValPair {
+			self.sequence(*rest.PairRight, shadowed)
+		}
+		return
+	case "begin", "begin-for-syntax":
+		self.sequence(rest, shadowed)
+		return
+	}
+	self.sequence(expression, shadowed)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// parseSymbol tells whether the text is a single symbol the way the reader
+// would read it.
+func parseSymbol(text string, foldCase bool) (string, bool) {
+	var parser Pars
+	parser.Lex.FoldCase = foldCase
+	expressions, err := parser.ParseAll(strings.NewReader(text))
+	if err != nil || len(expressions) != 1 || expressions[0].Type != ValSymbol {
+		return "", false
+	}
+	return expressions[0].Symbol, true
+}
+
+// Rename renames the top level name in the files and the files they include,
+// which are rewritten in place. Only the symbols of the name are replaced, so
+// the rest of the text including formatting and comments stays the same. The
+// local variables of the same name and the quoted symbols are left as is.
+// Nothing is written if the old name is not defined in the files, so that
+// builtins are not renamed by mistake, or if the new name would be captured
+// by a local variable, is already defined or used at the top level, or is a
+// builtin. Returns the exit code.
+func Rename(args []string, options Options) int {
+	if len(args) < 3 {
+		fmt.Fprintf(os.Stderr, "rename: old name, new name and files expected\n")
+		return 2
+	}
+	before, ok := parseSymbol(args[0], options.FoldCase)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "rename: not a symbol: %v\n", args[0])
+		return 2
+	}
+	after, ok := parseSymbol(args[1], options.FoldCase)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "rename: not a symbol: %v\n", args[1])
+		return 2
+	}
+	program, err := LoadProgram(args[2:], options.FoldCase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rename: %v\n", err)
+		return 2
+	}
+	renamed := make([][]Value, len(program))
+	var conflicts []string
+	if _, ok := Builtins()[after]; ok {
+		conflicts = append(conflicts, fmt.Sprintf("rename: `%v` is a builtin", after))
+	}
+	defined := false
+	for i, file := range program {
+		for _, reference := range TopLevelReferences(file.Expressions) {
+			symbol := reference.Symbol
+			if symbol.Symbol == after {
+				switch reference.Kind {
+				case RefDefinition:
+					conflicts = append(conflicts, NewError(symbol.Token.Source, symbol.Token.Offset,
+						fmt.Sprintf("`%v` is already defined here", after)).Error())
+				case RefUse:
+					conflicts = append(conflicts, NewError(symbol.Token.Source, symbol.Token.Offset,
+						fmt.Sprintf("`%v` is already used here", after)).Error())
+				}
+			}
+			if symbol.Symbol != before {
+				continue
+			}
+			if reference.Kind == RefDefinition {
+				defined = true
+			}
+			if reference.Scope[after] {
+				conflicts = append(conflicts, NewError(symbol.Token.Source, symbol.Token.Offset,
+					fmt.Sprintf("`%v` would refer to the local variable `%v` here", before, after)).Error())
+			}
+			renamed[i] = append(renamed[i], symbol)
+		}
+	}
+	if !defined {
+		conflicts = append(conflicts, fmt.Sprintf("rename: `%v` is not defined in the files", before))
+	}
+	if len(conflicts) > 0 {
+		for _, conflict := range conflicts {
+			fmt.Println(conflict)
+		}
+		return 1
+	}
+	for i, file := range program {
+		if len(renamed[i]) == 0 {
+			continue
+		}
+		text := file.Parser.Lex.Source.String()
+		symbols := renamed[i]
+		sort.Slice(symbols, func(a, b int) bool { return symbols[a].Token.Offset < symbols[b].Token.Offset })
+		var sb strings.Builder
+		last := 0
+		for _, symbol := range symbols {
+			sb.WriteString(text[last:symbol.Token.Offset])
+			sb.WriteString(args[1])
+			last = symbol.Token.Offset + symbol.Token.Length
+		}
+		sb.WriteString(text[last:])
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rename: %v\n", err)
+			return 2
+		}
+		if err := os.WriteFile(file.Path, []byte(sb.String()), info.Mode()); err != nil {
+			fmt.Fprintf(os.Stderr, "rename: %v\n", err)
+			return 2
+		}
+		fmt.Printf("%v: %v occurrences renamed\n", file.Path, len(symbols))
+	}
+	return 0
+}
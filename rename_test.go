@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles writes the files into a new temporary directory and returns the
+// path of the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, text := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func readFile(t *testing.T, path string) string {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(text)
+}
+
+func TestRenameUndefined(t *testing.T) {
+	const main = "(define (head x) (car x))\n(display (car '(1 2)))\n"
+	dir := writeFiles(t, map[string]string{"main.scm": main})
+	path := filepath.Join(dir, "main.scm")
+	if code := Rename([]string{"car", "kar", path}, Options{}); code != 1 {
+		t.Errorf("renaming the builtin must fail, exit code %v", code)
+	}
+	if text := readFile(t, path); text != main {
+		t.Errorf("file changed by failed rename:\n%v", text)
+	}
+}
+
+func TestRenameFollowsInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.scm": "(include \"lib.scm\")\n(display (square 3))\n",
+		"lib.scm":  "; Library\n(define (square x) (* x x))\n",
+	})
+	if code := Rename([]string{"square", "sq", filepath.Join(dir, "main.scm")}, Options{}); code != 0 {
+		t.Fatalf("rename failed, exit code %v", code)
+	}
+	if text := readFile(t, filepath.Join(dir, "main.scm")); text != "(include \"lib.scm\")\n(display (sq 3))\n" {
+		t.Errorf("main.scm not renamed:\n%v", text)
+	}
+	if text := readFile(t, filepath.Join(dir, "lib.scm")); text != "; Library\n(define (sq x) (* x x))\n" {
+		t.Errorf("included lib.scm not renamed:\n%v", text)
+	}
+}
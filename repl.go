@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type replCommand struct {
+	Help string
+	Run  func(args string, interp Interp, output io.Writer) error
+}
+
+var replCommands map[string]replCommand
+
+func init() {
+	// Initialized here, because `,help` refers to the map itself
+	replCommands = map[string]replCommand{
+		"help": {
+			Help: "list the commands",
+			Run:  helpCommand,
+		},
+		"dot": {
+			Help: "EXPR: print Graphviz DOT of the value, e.g. `,dot $1`",
+			Run:  dotCommand,
+		},
+		"history": {
+			Help: "[N]: list the entries of the session, or print the entry N",
+			Run:  historyCommand,
+		},
+		"edit": {
+			Help: "NAME: edit the definition in $EDITOR and evaluate it on save",
+			Run:  editCommand,
+		},
+	}
+}
+
+// ReadCommand reads REPL command line, which starts with a comma, if it is
+// the next thing in the input. Whitespace before it is consumed by the parser,
+// so the offsets of the following expressions stay correct.
+func ReadCommand(input *bufio.Reader, parser *Pars) (string, bool) {
+	if parser.Lex.state != LexIdle || len(parser.tokens) > 0 {
+		return "", false
+	}
+	for {
+		c, err := input.Peek(1)
+		if err != nil {
+			// Let the parser report it
+			return "", false
+		}
+		switch c[0] {
+		case ' ', '\t', '\r', '\n':
+			input.ReadByte()
+			parser.Lex.Consume(c[0])
+		case ',':
+			line, _ := input.ReadString('\n')
+			parser.Lex.Source.WriteString(line)
+			return strings.TrimSpace(line[1:]), true
+		default:
+			return "", false
+		}
+	}
+}
+
+// PasteContinues tells whether more of the input has arrived already together
+// with the form just parsed, as it does when several forms are pasted at once.
+// Whitespace and comments after the form are consumed by the parser, if they
+// have arrived. A command line ends the paste, see ReadCommand.
+func PasteContinues(input *bufio.Reader, parser *Pars) bool {
+	for len(parser.tokens) == 0 && input.Buffered() > 0 {
+		c, _ := input.Peek(1)
+		switch {
+		case parser.Lex.state == LexComment || c[0] == ';' ||
+			c[0] == ' ' || c[0] == '\t' || c[0] == '\r' || c[0] == '\n':
+			input.ReadByte()
+			tokens, _ := parser.Lex.Consume(c[0])
+			parser.tokens = append(parser.tokens, tokens...)
+		case c[0] == ',' && parser.Lex.state == LexIdle:
+			return false
+		default:
+			return true
+		}
+	}
+	return len(parser.tokens) > 0
+}
+
+// replForm is a form of the paste, parsed or failed to.
+type replForm struct {
+	expression Value
+	text       string
+	// end is the offset of the end of the form in the source
+	end int
+	err error
+}
+
+// History is the input of the REPL session, an entry per paste or line,
+// whichever the forms were given by.
+type History struct {
+	Entries []string
+}
+
+func (self *History) Add(entry string) {
+	if entry = strings.TrimSpace(entry); entry != "" {
+		self.Entries = append(self.Entries, entry)
+	}
+}
+
+func RunCommand(line string, interp Interp, output io.Writer) error {
+	name, args := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, args = line[:i], strings.TrimSpace(line[i:])
+	}
+	command, ok := replCommands[name]
+	if !ok {
+		return fmt.Errorf("unknown command `,%v`, see `,help`", name)
+	}
+	return command.Run(args, interp, output)
+}
+
+// EvalCommandArg evaluates expression given as the command argument.
+func EvalCommandArg(args string, interp Interp) (Value, error) {
+	var parser Pars
+	parser.Lex.Name = "<command>"
+	expression, err := parser.Parse(strings.NewReader(args), false)
+	if err == io.EOF {
+		return ValueNull(), fmt.Errorf("expression expected")
+	} else if err != nil {
+		return ValueNull(), err
+	}
+	interp.Register(&parser.Lex)
+	return interp.Eval(expression)
+}
+
+func helpCommand(args string, interp Interp, output io.Writer) error {
+	var names []string
+	for name := range replCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(output, ",%v %v\n", name, replCommands[name].Help)
+	}
+	return nil
+}
+
+func historyCommand(args string, interp Interp, output io.Writer) error {
+	if interp.History == nil {
+		return fmt.Errorf("no history")
+	}
+	entries := interp.History.Entries
+	if args != "" {
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 1 || n > len(entries) {
+			return fmt.Errorf("no history entry %v", args)
+		}
+		_, err = fmt.Fprintln(output, entries[n-1])
+		return err
+	}
+	for i, entry := range entries {
+		lines := strings.Split(entry, "\n")
+		for j, line := range lines {
+			if j == 0 {
+				fmt.Fprintf(output, "%4v  %v\n", i+1, line)
+			} else {
+				fmt.Fprintf(output, "      %v\n", line)
+			}
+		}
+	}
+	return nil
+}
+
+func dotCommand(args string, interp Interp, output io.Writer) error {
+	value, err := EvalCommandArg(args, interp)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(output, WriteDot(value))
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// requirement is a free name of a file, which the file uses but does not
+// define, see Requires.
+type requirement struct {
+	// first is the first use of the name in the source
+	first Value
+	uses  int
+}
+
+// freeNames returns the names the program uses, but none of its files
+// defines, in the order of their first use.
+func freeNames(program []ProgramFile) []*requirement {
+	var expressions []Value
+	for _, file := range program {
+		expressions = append(expressions, file.Expressions...)
+	}
+	references := TopLevelReferences(expressions)
+	defined := map[string]bool{}
+	for _, reference := range references {
+		if reference.Kind == RefDefinition {
+			defined[reference.Symbol.Symbol] = true
+		}
+	}
+	var free []*requirement
+	byName := map[string]*requirement{}
+	for _, reference := range references {
+		name := reference.Symbol.Symbol
+		if reference.Kind != RefUse || defined[name] {
+			continue
+		}
+		if byName[name] == nil {
+			byName[name] = &requirement{first: reference.Symbol}
+			free = append(free, byName[name])
+		}
+		byName[name].uses++
+	}
+	return free
+}
+
+// Requires reports what every file needs from the interpreter: the names the
+// file and the files it includes use without defining them, each telling
+// whether golisp provides it. It helps to port the programs from the other
+// Schemes, since the names golisp lacks, like `if`, are spotted without
+// running the program. The uses of macros are treated like calls, so the
+// names a macro binds in its arguments are reported as well. Returns the exit
+// code, which is 1 if some of the names are not provided.
+func Requires(args []string, options Options) int {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	files, err := FindSourceFiles(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "requires: %v\n", err)
+		return 2
+	}
+	builtins := Builtins()
+	code := 0
+	for _, path := range files {
+		program, err := LoadProgram([]string{path}, options.FoldCase)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "requires: %v\n", err)
+			return 2
+		}
+		for _, free := range freeNames(program) {
+			provided := "provided by golisp"
+			if _, ok := builtins[free.first.Symbol]; !ok {
+				provided = "not provided by golisp"
+				code = 1
+			}
+			times := "once"
+			if free.uses > 1 {
+				times = fmt.Sprintf("%v times", free.uses)
+			}
+			fmt.Println(NewError(free.first.Token.Source, free.first.Token.Offset, fmt.Sprintf(
+				"`%v` is %v, used %v", free.first.Symbol, provided, times)).Error())
+		}
+	}
+	return code
+}
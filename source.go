@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NamedSource is the text read by a lexer along with its name. Every token
+// refers to the source it was read from, so the errors are attributed to the
+// right file no matter where the value is evaluated, e.g. when a procedure
+// defined in an included file is called from the REPL.
+type NamedSource struct {
+	Name string
+	Text *strings.Builder
+	// lineStarts are the offsets of the lines scanned so far, see PositionFor,
+	// guarded by lines since the errors of the kernel are made concurrently
+	lines      sync.Mutex
+	lineStarts []int
+	scanned    int
+}
+
+// PositionFor returns 1-based line and column of the byte offset. Any of
+// "\n", "\r" and "\r\n" breaks the line. The text may grow between the calls,
+// so the line index is extended as far as needed instead of being rebuilt.
+func (self *NamedSource) PositionFor(offset int) (int, int) {
+	self.lines.Lock()
+	defer self.lines.Unlock()
+	text := self.Text.String()
+	if offset > len(text) {
+		offset = len(text)
+	}
+	if self.lineStarts == nil {
+		self.lineStarts = []int{0}
+	}
+	for ; self.scanned < offset; self.scanned++ {
+		switch text[self.scanned] {
+		case '\r':
+			self.lineStarts = append(self.lineStarts, self.scanned+1)
+		case '\n':
+			if self.scanned > 0 && text[self.scanned-1] == '\r' {
+				// The line has been started by '\r' already
+				self.lineStarts[len(self.lineStarts)-1]++
+			} else {
+				self.lineStarts = append(self.lineStarts, self.scanned+1)
+			}
+		}
+	}
+	// The last line starting at or before the offset
+	line := sort.SearchInts(self.lineStarts, offset+1) - 1
+	return line + 1, offset - self.lineStarts[line] + 1
+}
+
+// PositionFor returns 1-based line and column of the byte offset in the source
+// read by the lexer, see NamedSource.PositionFor.
+func (self *Lex) PositionFor(offset int) (int, int) {
+	return self.Named().PositionFor(offset)
+}
+
+// Sources is the registry of the sources read by an interpreter, keyed by
+// their names. It is shared between all the copies of the Interp.
+type Sources map[string]*NamedSource
+
+// Named returns the source of the lexer, which is created on first use, so
+// Lex.Name must be set before reading anything.
+func (self *Lex) Named() *NamedSource {
+	if self.named == nil {
+		self.named = &NamedSource{Name: self.Name, Text: &self.Source}
+	}
+	return self.named
+}
+
+// Register adds the source of the lexer into the registry and makes it the
+// current source of the interpreter, i.e. the one to report errors against
+// when the value has no location.
+func (self *Interp) Register(lex *Lex) {
+	source := lex.Named()
+	if self.Sources == nil {
+		self.Sources = Sources{}
+	}
+	self.Sources[source.Name] = source
+	self.SourceName = source.Name
+}
+
+// SourceOf returns the source the value was read from, falling back to the
+// current source of the interpreter.
+func (self Interp) SourceOf(value Value) *NamedSource {
+	if value.Token.Source != nil {
+		return value.Token.Source
+	}
+	if source, ok := self.Sources[self.SourceName]; ok {
+		return source
+	}
+	return &NamedSource{Name: self.SourceName, Text: &strings.Builder{}}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPositionForConcurrent(t *testing.T) {
+	text := &strings.Builder{}
+	text.WriteString("(define x 1)\r\n(display x)\n\n(newline)\r(x)")
+	expected := map[int][2]int{0: {1, 1}, 14: {2, 1}, 26: {3, 1}, 27: {4, 1}, 37: {5, 1}, 39: {5, 3}}
+	source := &NamedSource{Name: "test", Text: text}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for offset, position := range expected {
+				line, column := source.PositionFor(offset)
+				if line != position[0] || column != position[1] {
+					t.Errorf("offset %v: expected %v:%v, got %v:%v", offset, position[0], position[1], line, column)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type explanation struct {
+	// Text may refer to Error.Subject with %v
+	Text string
+	Hint string
+}
+
+var explanations = map[ErrorKind]explanation{
+	ErrUnexpectedByte: {
+		Text: "The character %v can't appear here. Only letters, digits, " +
+			"parentheses, quotes and a few punctuation characters like ! $ %% * + " +
+			"- / < = > ? are allowed in the program outside of strings and " +
+			"comments. Non-ASCII characters are not supported at all.",
+		Hint: "remove the character, or put it inside of a string if it is meant " +
+			"to be text.",
+	},
+	ErrUnexpectedToken: {
+		Text: "The reader did not expect `%v` at this place. A dot is only allowed " +
+			"before the last element of a list, like in (a . b), and the list must " +
+			"end right after the element that follows the dot.",
+		Hint: "check that the expression is written the way it is described in the " +
+			"manual.",
+	},
+	ErrUnexpectedRparen: {
+		Text: "There is a closing parenthesis `%v` that has no matching opening " +
+			"parenthesis. Every ( must be closed by exactly one ) and vice versa.",
+		Hint: "remove the extra ) or add the missing ( before it. An editor that " +
+			"highlights matching parentheses helps a lot.",
+	},
+	ErrUnexpectedEOF: {
+		Text: "The file ended in the middle of an expression, so the expression is " +
+			"incomplete. This usually means that some ( has never been closed, or a " +
+			"string has never been terminated by a \".",
+		Hint: "count the parentheses of the last expression in the file and add the " +
+			"missing ) at the end.",
+	},
+	ErrUnboundVariable: {
+		Text: "The name `%v` is used here, but nothing is defined with this name. " +
+			"A name must be defined with `define`, be a parameter of a procedure or " +
+			"be a built-in procedure before it can be used.",
+		Hint: "check the spelling of the name, or define it before the place it is " +
+			"used at. If it was meant to be a symbol, not a variable, quote it: 'name",
+	},
+	ErrNotProcedure: {
+		Text: "The first element of a list is what gets called, but `%v` is not a " +
+			"procedure, so it can't be called. For example, (1 2 3) tries to call " +
+			"the number 1.",
+		Hint: "if the list is data rather than a call, quote it: '(1 2 3). " +
+			"Otherwise check for extra parentheses around the expression.",
+	},
+	ErrArity: {
+		Text: "The procedure `%v` is given a wrong number of arguments. Every " +
+			"procedure expects a certain number of arguments, no more and no less.",
+		Hint: "check how many arguments the procedure expects and count the " +
+			"arguments of the call.",
+	},
+	ErrWrongType: {
+		Text: "The procedure `%v` is given an argument of a type it can't work with, " +
+			"like a number where a list is expected.",
+		Hint: "check what the argument evaluates to, e.g. by displaying it with " +
+			"(display ...) before the call.",
+	},
+	ErrContract: {
+		Text: "The procedure `%v` has a contract, which states what its arguments " +
+			"and its result must satisfy, and the contract was broken. When it is an " +
+			"argument that failed the predicate, the mistake is in the call. When it " +
+			"is the result, the mistake is in the procedure itself.",
+		Hint: "compare the value from the message with the predicate named in it, " +
+			"then fix either the call or the body of the procedure.",
+	},
+	ErrProtected: {
+		Text: "The name `%v` is protected by the program embedding the " +
+			"interpreter, so it can't be defined again. Protected names are usually " +
+			"the procedures the rest of the system relies on.",
+		Hint: "choose another name for the definition.",
+	},
+	ErrLimit: {
+		Text: "The form was stopped because it ran out of %v it is allowed to take. " +
+			"This usually means a loop or a recursion that never reaches its end, " +
+			"for example a recursive procedure without a base case.",
+		Hint: "check that every recursive call comes closer to the case that stops " +
+			"the recursion, like a smaller number or a shorter list.",
+	},
+	ErrCanceled: {
+		Text: "The evaluation was stopped from outside by the program running the " +
+			"interpreter, for example because the user interrupted it or it took too " +
+			"long. The code itself may be fine.",
+		Hint: "run it again, and if it keeps being stopped, check whether it takes " +
+			"longer than expected.",
+	},
+	ErrFrozen: {
+		Text: "The procedure `%v` tries to change a list or a vector that is frozen " +
+			"with `freeze!`. Frozen values can be read, but not modified, usually to " +
+			"protect configuration from being changed by accident.",
+		Hint: "make a copy of the value and change the copy instead.",
+	},
+}
+
+// Explain returns plain-language explanation of the error followed by a hint
+// line, or an empty string if there is nothing to add to the error text.
+func (e Error) Explain() string {
+	explanation, ok := explanations[e.Kind]
+	if !ok {
+		return ""
+	}
+	var sb strings.Builder
+	text := explanation.Text
+	if strings.Contains(text, "%v") {
+		text = fmt.Sprintf(text, e.Subject)
+	}
+	for _, line := range wrapText(text, 76) {
+		sb.WriteString("  ")
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	for i, line := range wrapText("Hint: "+explanation.Hint, 76) {
+		if i != 0 {
+			// Align with the text after "Hint: "
+			sb.WriteString("      ")
+		}
+		sb.WriteString("  ")
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// ExplainError explains the error if it is an Error.
+func ExplainError(err error) string {
+	var e Error
+	if errors.As(err, &e) {
+		return e.Explain()
+	}
+	return ""
+}
+
+func wrapText(text string, width int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Tracer writes one JSON object per line for every entry to and exit from
+// Interp.Eval, so the evaluation can be analyzed by external tools.
+type Tracer struct {
+	encoder *json.Encoder
+}
+
+type TraceEvent struct {
+	// Event is either "enter" or "exit"
+	Event string `json:"event"`
+	// Time is in microseconds since Unix epoch
+	Time int64 `json:"ts"`
+	// Duration of the evaluation in microseconds, exit events only
+	Duration *int64 `json:"dur,omitempty"`
+	Source   string `json:"source"`
+	// Start and End are the offsets of the expression in the source
+	Start      int    `json:"start"`
+	End        int    `json:"end"`
+	Depth      int    `json:"depth"`
+	Expression string `json:"expression"`
+	Error      string `json:"error,omitempty"`
+}
+
+func NewTracer(output io.Writer) *Tracer {
+	return &Tracer{json.NewEncoder(output)}
+}
+
+// tracePrinter abbreviates the expressions, otherwise the trace of a deeply
+// nested code would be quadratic in size.
+var tracePrinter = Printer{Level: 3, Length: 8}
+
+func (self *Interp) EnvDepth() int {
+	var depth int
+	for env := self.Env; env != nil; env = env.Parent {
+		depth++
+	}
+	return depth
+}
+
+// traceEval wraps evaluation of the expression with enter and exit events.
+func (self *Interp) traceEval(expression Value, eval func() (Value, error)) (Value, error) {
+	start, end := Span(expression)
+	event := TraceEvent{
+		Event:      "enter",
+		Time:       time.Now().UnixNano() / 1000,
+		Source:     self.SourceOf(expression).Name,
+		Start:      start,
+		End:        end,
+		Depth:      self.EnvDepth(),
+		Expression: tracePrinter.Sprint(expression),
+	}
+	self.Trace.encoder.Encode(event)
+	result, err := eval()
+	now := time.Now().UnixNano() / 1000
+	duration := now - event.Time
+	event.Event, event.Time, event.Duration = "exit", now, &duration
+	if err != nil {
+		event.Error = err.Error()
+	}
+	self.Trace.encoder.Encode(event)
+	return result, err
+}
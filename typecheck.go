@@ -0,0 +1,342 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// StaticType is a type of `(: name type)` annotation. It is either one of the
+// baseTypes or a procedure type `(-> param... result)`.
+type StaticType struct {
+	Name string
+	// Params and Result are set for procedure types only
+	Params []StaticType
+	Result *StaticType
+}
+
+var baseTypes = map[string]bool{
+	"any":       true,
+	"number":    true,
+	"string":    true,
+	"boolean":   true,
+	"char":      true,
+	"symbol":    true,
+	"list":      true,
+	"vector":    true,
+	"procedure": true,
+}
+
+var anyType = StaticType{Name: "any"}
+
+func (t StaticType) String() string {
+	if t.Result == nil {
+		return t.Name
+	}
+	var sb strings.Builder
+	sb.WriteString("(->")
+	for _, param := range t.Params {
+		sb.WriteString(" ")
+		sb.WriteString(param.String())
+	}
+	sb.WriteString(" ")
+	sb.WriteString(t.Result.String())
+	sb.WriteString(")")
+	return sb.String()
+}
+
+// Accepts tells whether the value of the actual type may be passed where the
+// type is expected. Unknown type is always accepted, the check is advisory.
+// Procedure types are not compared beyond being procedures.
+func (t StaticType) Accepts(actual StaticType) bool {
+	return t.Name == "any" || actual.Name == "any" || t.Name == actual.Name
+}
+
+// ParseStaticType converts the type expression of an annotation.
+func ParseStaticType(v Value) (StaticType, bool) {
+	if v.Type == ValSymbol && baseTypes[v.Symbol] {
+		return StaticType{Name: v.Symbol}, true
+	}
+	if v.Type != ValPair || v.PairLeft.Type != ValSymbol || v.PairLeft.Symbol != "->" {
+		return StaticType{}, false
+	}
+	var types []StaticType
+	for list := *v.PairRight; list.Type != ValNull; list = *list.PairRight {
+		if list.Type != ValPair {
+			return StaticType{}, false
+		}
+		t, ok := ParseStaticType(*list.PairLeft)
+		if !ok {
+			return StaticType{}, false
+		}
+		types = append(types, t)
+	}
+	if len(types) == 0 {
+		return StaticType{}, false
+	}
+	result := types[len(types)-1]
+	return StaticType{Name: "procedure", Params: types[:len(types)-1], Result: &result}, true
+}
+
+// LiteralType returns the type of a self-evaluating or quoted value.
+func LiteralType(v Value) StaticType {
+	switch v.Type {
+	case ValNumber, ValDecimal, ValComplex:
+		return StaticType{Name: "number"}
+	case ValString:
+		return StaticType{Name: "string"}
+	case ValBool:
+		return StaticType{Name: "boolean"}
+	case ValChar:
+		return StaticType{Name: "char"}
+	case ValSymbol:
+		return StaticType{Name: "symbol"}
+	case ValNull, ValPair:
+		return StaticType{Name: "list"}
+	case ValVector:
+		return StaticType{Name: "vector"}
+	}
+	return anyType
+}
+
+// typeChecker finds obvious mismatches between the annotations and the code:
+// calls of annotated procedures with wrong count or types of arguments and
+// definitions that contradict their annotations. Only literals, annotated
+// names and results of annotated procedures have known types.
+type typeChecker struct {
+	types  map[string]StaticType
+	macros map[string]bool
+	// problems are errors formatted like evaluation errors
+	problems []string
+}
+
+func (self *typeChecker) report(v Value, text string) {
+	self.problems = append(self.problems, NewError(v.Token.Source, v.Token.Offset, text).Error())
+}
+
+// collect gathers annotations and macro names of the top level, so the order
+// of annotations and definitions does not matter.
+func (self *typeChecker) collect(expression Value) {
+	if expression.Type != ValPair || expression.PairLeft.Type != ValSymbol {
+		return
+	}
+	args, _ := Interp{}.RangeArgs(*expression.PairRight, "", 0, MaxArgs)
+	switch expression.PairLeft.Symbol {
+	case ":":
+		if len(args) != 2 || args[0].Type != ValSymbol {
+			self.report(expression, fmt.Sprintf(
+				"`:` expects name and type, given: %v", Printer{}.Sprint(expression)))
+			return
+		}
+		t, ok := ParseStaticType(args[1])
+		if !ok {
+			self.report(args[1], fmt.Sprintf(
+				"Unknown type: %v", Printer{}.Sprint(args[1])))
+			return
+		}
+		self.types[args[0].Symbol] = t
+	case "define-macro":
+		if len(args) > 0 && args[0].Type == ValPair && args[0].PairLeft.Type == ValSymbol {
+			self.macros[args[0].PairLeft.Symbol] = true
+		}
+	case "begin":
+		for _, arg := range args {
+			self.collect(arg)
+		}
+	}
+}
+
+// params adds the names of procedure parameters to the names shadowing the
+// annotations and returns the count of required parameters, the last result
+// tells whether there is a rest parameter.
+func params(list Value, shadowed map[string]bool) (map[string]bool, int, bool) {
+	inner := map[string]bool{}
+	for name := range shadowed {
+		inner[name] = true
+	}
+	var count int
+	for ; list.Type == ValPair; list = *list.PairRight {
+		if list.PairLeft.Type == ValSymbol {
+			inner[list.PairLeft.Symbol] = true
+		}
+		count++
+	}
+	if list.Type == ValSymbol {
+		inner[list.Symbol] = true
+		return inner, count, true
+	}
+	return inner, count, false
+}
+
+// bindPattern adds the names of `destructuring-bind` pattern to the names
+// shadowing the annotations.
+func bindPattern(pattern Value, shadowed map[string]bool) map[string]bool {
+	inner, _, _ := params(ValueNull(), shadowed)
+	for _, name := range patternNames(pattern, nil) {
+		inner[name.Symbol] = true
+	}
+	return inner
+}
+
+func (self *typeChecker) lookup(name string, shadowed map[string]bool) (StaticType, bool) {
+	if shadowed[name] {
+		return anyType, false
+	}
+	t, ok := self.types[name]
+	return t, ok
+}
+
+func (self *typeChecker) body(list Value, shadowed map[string]bool) StaticType {
+	result := anyType
+	for ; list.Type == ValPair; list = *list.PairRight {
+		result = self.check(*list.PairLeft, shadowed)
+	}
+	return result
+}
+
+// check returns the type of the expression, where the names in shadowed are
+// local variables hiding the annotated top level names.
+func (self *typeChecker) check(expression Value, shadowed map[string]bool) StaticType {
+	if expression.Type == ValSymbol {
+		t, _ := self.lookup(expression.Symbol, shadowed)
+		return t
+	}
+	if expression.Type != ValPair {
+		return LiteralType(expression)
+	}
+	head := *expression.PairLeft
+	args, err := Interp{}.RangeArgs(*expression.PairRight, "", 0, MaxArgs)
+	if err != nil {
+		return anyType
+	}
+	if head.Type == ValSymbol && !shadowed[head.Symbol] {
+		switch head.Symbol {
+		case "quote":
+			if len(args) == 1 {
+				return LiteralType(args[0])
+			}
+			return anyType
+		case ":", "define-macro", "begin-for-syntax", "eval-when", "include", "include-ci":
+			return anyType
+		case "begin":
+			return self.body(*expression.PairRight, shadowed)
+		case "lambda":
+			if len(args) == 0 {
+				return anyType
+			}
+			inner, _, _ := params(args[0], shadowed)
+			self.body(*expression.PairRight.PairRight, inner)
+			return StaticType{Name: "procedure"}
+		case "define":
+			self.checkDefine(args, *expression.PairRight, shadowed)
+			return anyType
+		case "destructuring-bind":
+			if len(args) < 3 {
+				return anyType
+			}
+			self.check(args[1], shadowed)
+			return self.body(*expression.PairRight.PairRight.PairRight, bindPattern(args[0], shadowed))
+		}
+		if self.macros[head.Symbol] {
+			return anyType
+		}
+	}
+	actual := make([]StaticType, len(args))
+	for i, arg := range args {
+		actual[i] = self.check(arg, shadowed)
+	}
+	if head.Type != ValSymbol {
+		self.check(head, shadowed)
+		return anyType
+	}
+	t, ok := self.lookup(head.Symbol, shadowed)
+	if !ok {
+		return anyType
+	}
+	if t.Name != "procedure" && t.Name != "any" {
+		self.report(head, fmt.Sprintf(
+			"`%v` is declared as %v, but it is called as procedure", head.Symbol, t))
+		return anyType
+	}
+	if t.Result == nil {
+		return anyType
+	}
+	if len(args) != len(t.Params) {
+		self.report(head, fmt.Sprintf(
+			"`%v` is declared as %v, which takes %v arguments, but given %v",
+			head.Symbol, t, len(t.Params), len(args)))
+		return *t.Result
+	}
+	for i, arg := range args {
+		if !t.Params[i].Accepts(actual[i]) {
+			self.report(arg, fmt.Sprintf(
+				"`%v` expects %v as argument %v, given %v: %v",
+				head.Symbol, t.Params[i], i+1, actual[i], Printer{}.Sprint(arg)))
+		}
+	}
+	return *t.Result
+}
+
+func (self *typeChecker) checkDefine(args []Value, rest Value, shadowed map[string]bool) {
+	if len(args) == 0 {
+		return
+	}
+	target := args[0]
+	if target.Type == ValPair && target.PairLeft.Type == ValSymbol {
+		name := *target.PairLeft
+		inner, count, variadic := params(*target.PairRight, shadowed)
+		self.body(*rest.PairRight, inner)
+		t, ok := self.lookup(name.Symbol, shadowed)
+		if !ok || t.Name == "any" {
+			return
+		}
+		if t.Name != "procedure" {
+			self.report(name, fmt.Sprintf(
+				"`%v` is declared as %v, but defined as procedure", name.Symbol, t))
+		} else if t.Result != nil && !variadic && count != len(t.Params) {
+			self.report(name, fmt.Sprintf(
+				"`%v` is declared as %v, which takes %v arguments, but defined with %v",
+				name.Symbol, t, len(t.Params), count))
+		}
+		return
+	}
+	if target.Type != ValSymbol || len(args) != 2 {
+		return
+	}
+	actual := self.check(args[1], shadowed)
+	if t, ok := self.lookup(target.Symbol, shadowed); ok && !t.Accepts(actual) {
+		self.report(args[1], fmt.Sprintf(
+			"`%v` is declared as %v, but defined as %v", target.Symbol, t, actual))
+	}
+}
+
+// Typecheck checks the files against the type annotations found in all of them
+// and prints the mismatches. Annotations are ignored by the evaluator, so this
+// is the only place they matter. Returns the exit code.
+func Typecheck(files []string, options Options) int {
+	checker := typeChecker{types: map[string]StaticType{}, macros: map[string]bool{}}
+	var programs [][]Value
+	for _, file := range files {
+		_, expressions, err := ParseFile(file, options.FoldCase)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "typecheck: %v\n", err)
+			return 2
+		}
+		programs = append(programs, expressions)
+		for _, expression := range expressions {
+			checker.collect(expression)
+		}
+	}
+	for _, expressions := range programs {
+		for _, expression := range expressions {
+			checker.check(expression, nil)
+		}
+	}
+	for _, problem := range checker.problems {
+		fmt.Println(problem)
+	}
+	if len(checker.problems) > 0 {
+		return 1
+	}
+	return 0
+}
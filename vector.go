@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Vector holds elements of both ValVector and ValArray values. Values share the
+// Vector by pointer, so mutation is visible through every copy of a value.
+type Vector struct {
+	Items []Value
+	// Dims is set for ValArray only, elements are stored in row-major order.
+	Dims []int
+	// Frozen vectors can't be modified, see Freeze
+	Frozen bool
+}
+
+func (v Vector) String() string {
+	var sb strings.Builder
+	v.writeItems(&sb, map[interface{}]bool{})
+	return sb.String()
+}
+
+func (v *Vector) writeItems(sb *strings.Builder, path map[interface{}]bool) {
+	sb.WriteString("#(")
+	for i, item := range v.Items {
+		if i != 0 {
+			sb.WriteString(" ")
+		}
+		writeNested(sb, item, path)
+	}
+	sb.WriteString(")")
+}
+
+func ValueVector(items []Value) Value {
+	return Value{Type: ValVector, Vector: &Vector{Items: items}}
+}
+
+func (self Interp) VectorArg(v Value, name string) (*Vector, error) {
+	if v.Type != ValVector {
+		_, err := self.NewEvalErrorOf(ErrWrongType, name, v, fmt.Sprintf(
+			"`%v` expects ValVector argument, given: %v", name, v))
+		return nil, err
+	}
+	return v.Vector, nil
+}
+
+// NumericVectorArg accepts both vectors and arrays, as long as all the
+// elements are numbers.
+func (self Interp) NumericVectorArg(v Value, name string) (*Vector, error) {
+	if v.Type != ValVector && v.Type != ValArray {
+		_, err := self.NewEvalErrorOf(ErrWrongType, name, v, fmt.Sprintf(
+			"`%v` expects ValVector or ValArray argument, given: %v", name, v))
+		return nil, err
+	}
+	for i, item := range v.Vector.Items {
+		if !IsNumber(item) {
+			_, err := self.NewEvalError(v, fmt.Sprintf(
+				"`%v` expects numeric elements, given %v at index %v", name, item, i))
+			return nil, err
+		}
+	}
+	return v.Vector, nil
+}
+
+func (self Interp) IndexArg(v Value, length int, name string) (int, error) {
+	if v.Type != ValNumber || v.Number < 0 || v.Number >= length {
+		_, err := self.NewEvalError(v, fmt.Sprintf(
+			"`%v` expects index in range [0, %v), given: %v", name, length, v))
+		return 0, err
+	}
+	return v.Number, nil
+}
+
+func vectorFn(arg Value, interp Interp) (Value, error) {
+	items, err := interp.RangeArgs(arg, "vector", 0, MaxArgs)
+	if err != nil {
+		return ValueNull(), err
+	}
+	return ValueVector(items), nil
+}
+
+func makeVectorFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "make-vector", 1, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	if args[0].Type != ValNumber || args[0].Number < 0 {
+		return interp.NewEvalError(args[0], fmt.Sprintf(
+			"`make-vector` expects non-negative length, given: %v", args[0]))
+	}
+	fill := Value{Type: ValNumber, Number: 0}
+	if len(args) == 2 {
+		fill = args[1]
+	}
+	items := make([]Value, args[0].Number)
+	for i := range items {
+		items[i] = fill
+	}
+	return ValueVector(items), nil
+}
+
+func vectorLengthFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "vector-length")
+	if err != nil {
+		return ValueNull(), err
+	}
+	vector, err := interp.VectorArg(value, "vector-length")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return Value{Type: ValNumber, Number: len(vector.Items)}, nil
+}
+
+func vectorRefFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "vector-ref", 2, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	vector, err := interp.VectorArg(args[0], "vector-ref")
+	if err != nil {
+		return ValueNull(), err
+	}
+	index, err := interp.IndexArg(args[1], len(vector.Items), "vector-ref")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return vector.Items[index], nil
+}
+
+func vectorSetFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "vector-set!", 3, 3)
+	if err != nil {
+		return ValueNull(), err
+	}
+	vector, err := interp.VectorArg(args[0], "vector-set!")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if err := interp.MutableArg(args[0], "vector-set!"); err != nil {
+		return ValueNull(), err
+	}
+	index, err := interp.IndexArg(args[1], len(vector.Items), "vector-set!")
+	if err != nil {
+		return ValueNull(), err
+	}
+	vector.Items[index] = args[2]
+	return ValueNull(), nil
+}
+
+func vectorToListFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "vector->list")
+	if err != nil {
+		return ValueNull(), err
+	}
+	vector, err := interp.VectorArg(value, "vector->list")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return NewList(vector.Items), nil
+}
+
+func listToVectorFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "list->vector")
+	if err != nil {
+		return ValueNull(), err
+	}
+	items, err := interp.RangeArgs(value, "list->vector", 0, MaxArgs)
+	if err != nil {
+		return ValueNull(), err
+	}
+	return ValueVector(items), nil
+}
+
+func vectorSumFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "vector-sum")
+	if err != nil {
+		return ValueNull(), err
+	}
+	vector, err := interp.NumericVectorArg(value, "vector-sum")
+	if err != nil {
+		return ValueNull(), err
+	}
+	acc := Value{Type: ValNumber, Number: 0}
+	for _, item := range vector.Items {
+		acc = AddNumbers(acc, item)
+	}
+	return acc, nil
+}
+
+func vectorDotFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "vector-dot", 2, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	a, err := interp.NumericVectorArg(args[0], "vector-dot")
+	if err != nil {
+		return ValueNull(), err
+	}
+	b, err := interp.NumericVectorArg(args[1], "vector-dot")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if len(a.Items) != len(b.Items) {
+		return interp.NewEvalError(args[1], fmt.Sprintf(
+			"`vector-dot` expects vectors of equal length, given lengths %v and %v",
+			len(a.Items), len(b.Items)))
+	}
+	acc := Value{Type: ValNumber, Number: 0}
+	for i := range a.Items {
+		acc = AddNumbers(acc, MulNumbers(a.Items[i], b.Items[i]))
+	}
+	return acc, nil
+}
+
+func vectorScaleFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "vector-scale", 2, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	vector, err := interp.NumericVectorArg(args[0], "vector-scale")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if !IsNumber(args[1]) {
+		return interp.NewEvalError(args[1], fmt.Sprintf(
+			"`vector-scale` expects number, given: %v", args[1]))
+	}
+	items := make([]Value, len(vector.Items))
+	for i, item := range vector.Items {
+		items[i] = MulNumbers(item, args[1])
+	}
+	result := args[0]
+	result.Vector = &Vector{Items: items, Dims: vector.Dims}
+	return result, nil
+}
+
+func makeArrayFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "make-array", 1, 2)
+	if err != nil {
+		return ValueNull(), err
+	}
+	dimsList, err := interp.RangeArgs(args[0], "make-array", 1, MaxArgs)
+	if err != nil {
+		return ValueNull(), err
+	}
+	dims := make([]int, len(dimsList))
+	size := 1
+	for i, dim := range dimsList {
+		if dim.Type != ValNumber || dim.Number < 0 {
+			return interp.NewEvalError(dim, fmt.Sprintf(
+				"`make-array` expects non-negative dimensions, given: %v", dim))
+		}
+		dims[i] = dim.Number
+		size *= dim.Number
+	}
+	fill := Value{Type: ValNumber, Number: 0}
+	if len(args) == 2 {
+		fill = args[1]
+	}
+	items := make([]Value, size)
+	for i := range items {
+		items[i] = fill
+	}
+	return Value{Type: ValArray, Vector: &Vector{Items: items, Dims: dims}}, nil
+}
+
+func (self Interp) ArrayArg(v Value, name string) (*Vector, error) {
+	if v.Type != ValArray {
+		_, err := self.NewEvalErrorOf(ErrWrongType, name, v, fmt.Sprintf(
+			"`%v` expects ValArray argument, given: %v", name, v))
+		return nil, err
+	}
+	return v.Vector, nil
+}
+
+// ArrayIndex converts the subscripts into the index of the element in
+// row-major order.
+func (self Interp) ArrayIndex(array *Vector, subscripts []Value, name string) (int, error) {
+	if len(subscripts) != len(array.Dims) {
+		_, err := self.NewEvalError(NewList(subscripts), fmt.Sprintf(
+			"`%v` expects %v subscripts, given %v", name, len(array.Dims), len(subscripts)))
+		return 0, err
+	}
+	var index int
+	for i, subscript := range subscripts {
+		s, err := self.IndexArg(subscript, array.Dims[i], name)
+		if err != nil {
+			return 0, err
+		}
+		index = index*array.Dims[i] + s
+	}
+	return index, nil
+}
+
+func arrayDimensionsFn(arg Value, interp Interp) (Value, error) {
+	value, err := interp.SingleArg(arg, "array-dimensions")
+	if err != nil {
+		return ValueNull(), err
+	}
+	array, err := interp.ArrayArg(value, "array-dimensions")
+	if err != nil {
+		return ValueNull(), err
+	}
+	dims := make([]Value, len(array.Dims))
+	for i, dim := range array.Dims {
+		dims[i] = Value{Type: ValNumber, Number: dim}
+	}
+	return NewList(dims), nil
+}
+
+func arrayRefFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "array-ref", 1, MaxArgs)
+	if err != nil {
+		return ValueNull(), err
+	}
+	array, err := interp.ArrayArg(args[0], "array-ref")
+	if err != nil {
+		return ValueNull(), err
+	}
+	index, err := interp.ArrayIndex(array, args[1:], "array-ref")
+	if err != nil {
+		return ValueNull(), err
+	}
+	return array.Items[index], nil
+}
+
+func arraySetFn(arg Value, interp Interp) (Value, error) {
+	args, err := interp.RangeArgs(arg, "array-set!", 2, MaxArgs)
+	if err != nil {
+		return ValueNull(), err
+	}
+	array, err := interp.ArrayArg(args[0], "array-set!")
+	if err != nil {
+		return ValueNull(), err
+	}
+	if err := interp.MutableArg(args[0], "array-set!"); err != nil {
+		return ValueNull(), err
+	}
+	last := len(args) - 1
+	index, err := interp.ArrayIndex(array, args[1:last], "array-set!")
+	if err != nil {
+		return ValueNull(), err
+	}
+	array.Items[index] = args[last]
+	return ValueNull(), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// XrefSite is a location of a symbol, lines and columns start from 1, offsets
+// are in bytes from the start of the file and End is exclusive.
+type XrefSite struct {
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"end_line"`
+	EndColumn int    `json:"end_column"`
+	Start     int    `json:"start"`
+	End       int    `json:"end"`
+}
+
+// XrefSymbol is the entry of a top level name in the index. Builtin names
+// have no definitions in the source.
+type XrefSymbol struct {
+	Name        string     `json:"name"`
+	Builtin     bool       `json:"builtin,omitempty"`
+	Definitions []XrefSite `json:"definitions"`
+	Annotations []XrefSite `json:"annotations,omitempty"`
+	References  []XrefSite `json:"references"`
+}
+
+func NewXrefSite(symbol Value) XrefSite {
+	token := symbol.Token
+	site := XrefSite{Start: token.Offset, End: token.Offset + token.Length}
+	if token.Source != nil {
+		site.File = token.Source.Name
+		site.Line, site.Column = token.Source.PositionFor(site.Start)
+		site.EndLine, site.EndColumn = token.Source.PositionFor(site.End)
+	}
+	return site
+}
+
+// Xref builds the index of the definitions and references of the top level
+// names, see TopLevelReferences, sorted by name. The included files are
+// followed. Local variables are not indexed. Returns the exit code.
+func Xref(args []string, options Options) int {
+	flags := flag.NewFlagSet("xref", flag.ExitOnError)
+	out := flags.String("out", "", "write the index into the `file` instead of standard output")
+	paths := parseInterspersed(flags, args)
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	program, err := LoadProgram(paths, options.FoldCase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "xref: %v\n", err)
+		return 2
+	}
+	builtins := Builtins()
+	symbols := map[string]*XrefSymbol{}
+	for _, file := range program {
+		for _, reference := range TopLevelReferences(file.Expressions) {
+			name := reference.Symbol.Symbol
+			symbol, ok := symbols[name]
+			if !ok {
+				_, builtin := builtins[name]
+				symbol = &XrefSymbol{Name: name, Builtin: builtin,
+					Definitions: []XrefSite{}, References: []XrefSite{}}
+				symbols[name] = symbol
+			}
+			site := NewXrefSite(reference.Symbol)
+			switch reference.Kind {
+			case RefDefinition:
+				symbol.Definitions = append(symbol.Definitions, site)
+			case RefAnnotation:
+				symbol.Annotations = append(symbol.Annotations, site)
+			default:
+				symbol.References = append(symbol.References, site)
+			}
+		}
+	}
+	index := make([]*XrefSymbol, 0, len(symbols))
+	for _, symbol := range symbols {
+		index = append(index, symbol)
+	}
+	sort.Slice(index, func(i, j int) bool { return index[i].Name < index[j].Name })
+	var output io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "xref: %v\n", err)
+			return 2
+		}
+		defer file.Close()
+		output = file
+	}
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(index); err != nil {
+		fmt.Fprintf(os.Stderr, "xref: %v\n", err)
+		return 2
+	}
+	return 0
+}
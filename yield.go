@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// Yielder makes the evaluation cooperative for the programs embedding the
+// interpreter: every Interval steps the goroutine yields to the others and
+// the context is checked, so a busy loop of Lisp code neither starves the
+// other goroutines nor outlives its cancellation. It is not safe to share a
+// Yielder between interpreters running concurrently.
+type Yielder struct {
+	// Interval is the number of Interp.Eval calls between yield points,
+	// every call is one if it is not positive
+	Interval int
+	// Context cancels the evaluation at the next yield point, if set
+	Context context.Context
+	steps   int
+}
+
+// NewYielder creates a yielder with the interval, the context may be nil.
+func NewYielder(ctx context.Context, interval int) *Yielder {
+	return &Yielder{Interval: interval, Context: ctx}
+}
+
+// yield counts the step and yields if it is time to, the error is located at
+// the expression being evaluated when the cancellation is noticed.
+func (self *Interp) yield(expression Value) error {
+	yielder := self.Yielder
+	yielder.steps++
+	if yielder.steps < yielder.Interval {
+		return nil
+	}
+	yielder.steps = 0
+	runtime.Gosched()
+	if yielder.Context == nil {
+		return nil
+	}
+	if err := yielder.Context.Err(); err != nil {
+		_, err := self.NewEvalErrorOf(ErrCanceled, "", expression, fmt.Sprintf(
+			"Evaluation canceled: %v", err))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// This is just enough of ZeroMQ message transport protocol (ZMTP 3.0) for the
+// Jupyter kernel: the server side of ROUTER, PUB and REP sockets over TCP with
+// NULL security mechanism, so no dependency on libzmq is needed. Every
+// connection is a peer of its own, replies are written back to the connection
+// the request came from, so ROUTER needs no identities.
+
+const (
+	zmtpMore    = 0x01
+	zmtpLong    = 0x02
+	zmtpCommand = 0x04
+	// zmtpMaxFrame limits the size of frames the peer sends, since the size
+	// comes before the frame and memory for it is allocated up front
+	zmtpMaxFrame = 64 << 20
+)
+
+// ZmtpConn is a connection to a single peer after the handshake.
+type ZmtpConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// writes of multipart messages must not interleave
+	mutex sync.Mutex
+}
+
+func zmtpGreeting() []byte {
+	greeting := make([]byte, 64)
+	greeting[0] = 0xff
+	greeting[9] = 0x7f
+	greeting[10] = 3 // Version 3.0
+	copy(greeting[12:32], "NULL")
+	return greeting
+}
+
+func zmtpReady(socketType string) []byte {
+	var body bytes.Buffer
+	body.WriteByte(5)
+	body.WriteString("READY")
+	body.WriteByte(byte(len("Socket-Type")))
+	body.WriteString("Socket-Type")
+	binary.Write(&body, binary.BigEndian, uint32(len(socketType)))
+	body.WriteString(socketType)
+	return body.Bytes()
+}
+
+// NewZmtpConn performs the handshake as the socket of the given type.
+func NewZmtpConn(conn net.Conn, socketType string) (*ZmtpConn, error) {
+	self := &ZmtpConn{conn: conn, reader: bufio.NewReader(conn)}
+	if _, err := conn.Write(zmtpGreeting()); err != nil {
+		return nil, err
+	}
+	greeting := make([]byte, 64)
+	if _, err := io.ReadFull(self.reader, greeting); err != nil {
+		return nil, err
+	}
+	if greeting[0] != 0xff || greeting[9]&1 != 1 || greeting[10] < 3 {
+		return nil, fmt.Errorf("ZMTP 3 peer expected")
+	}
+	if mechanism := string(bytes.TrimRight(greeting[12:32], "\x00")); mechanism != "NULL" {
+		return nil, fmt.Errorf("unsupported security mechanism %v", mechanism)
+	}
+	if err := self.writeFrame(zmtpReady(socketType), zmtpCommand); err != nil {
+		return nil, err
+	}
+	for {
+		body, flags, err := self.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if flags&zmtpCommand == 0 || len(body) == 0 || int(body[0]) >= len(body) {
+			return nil, fmt.Errorf("READY command expected")
+		}
+		switch name := string(body[1 : 1+body[0]]); name {
+		case "READY":
+			return self, nil
+		case "ERROR":
+			return nil, fmt.Errorf("peer error: %q", body[1+body[0]:])
+		}
+	}
+}
+
+func (self *ZmtpConn) readFrame() ([]byte, byte, error) {
+	flags, err := self.reader.ReadByte()
+	if err != nil {
+		return nil, 0, err
+	}
+	var size uint64
+	if flags&zmtpLong != 0 {
+		err = binary.Read(self.reader, binary.BigEndian, &size)
+	} else {
+		var short byte
+		short, err = self.reader.ReadByte()
+		size = uint64(short)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if size > zmtpMaxFrame {
+		return nil, 0, fmt.Errorf("zmtp: frame of %v bytes exceeds the limit of %v bytes", size, zmtpMaxFrame)
+	}
+	body := make([]byte, size)
+	_, err = io.ReadFull(self.reader, body)
+	return body, flags, err
+}
+
+func (self *ZmtpConn) writeFrame(body []byte, flags byte) error {
+	var header []byte
+	if len(body) > 255 {
+		header = make([]byte, 9)
+		header[0] = flags | zmtpLong
+		binary.BigEndian.PutUint64(header[1:], uint64(len(body)))
+	} else {
+		header = []byte{flags, byte(len(body))}
+	}
+	if _, err := self.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := self.conn.Write(body)
+	return err
+}
+
+// ReadMessage reads the next multipart message, commands are skipped.
+func (self *ZmtpConn) ReadMessage() ([][]byte, error) {
+	var frames [][]byte
+	for {
+		body, flags, err := self.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if flags&zmtpCommand != 0 {
+			continue
+		}
+		frames = append(frames, body)
+		if flags&zmtpMore == 0 {
+			return frames, nil
+		}
+	}
+}
+
+func (self *ZmtpConn) WriteMessage(frames [][]byte) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for i, frame := range frames {
+		var flags byte
+		if i != len(frames)-1 {
+			flags = zmtpMore
+		}
+		if err := self.writeFrame(frame, flags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ZmtpServe accepts the peers and runs the handler for every one of them in
+// its own goroutine.
+func ZmtpServe(listener net.Listener, socketType string, handler func(*ZmtpConn)) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			peer, err := NewZmtpConn(conn, socketType)
+			if err != nil {
+				return
+			}
+			handler(peer)
+		}()
+	}
+}
+
+// ZmtpPublisher is PUB socket, every message goes to every subscriber. The
+// subscriptions are not tracked, Jupyter clients subscribe to everything.
+type ZmtpPublisher struct {
+	mutex       sync.Mutex
+	subscribers map[*ZmtpConn]bool
+}
+
+func NewZmtpPublisher(listener net.Listener) *ZmtpPublisher {
+	self := &ZmtpPublisher{subscribers: map[*ZmtpConn]bool{}}
+	go ZmtpServe(listener, "PUB", func(peer *ZmtpConn) {
+		self.mutex.Lock()
+		self.subscribers[peer] = true
+		self.mutex.Unlock()
+		// Subscriptions are read until the peer is gone
+		for {
+			if _, err := peer.ReadMessage(); err != nil {
+				break
+			}
+		}
+		self.mutex.Lock()
+		delete(self.subscribers, peer)
+		self.mutex.Unlock()
+	})
+	return self
+}
+
+func (self *ZmtpPublisher) Publish(frames [][]byte) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for peer := range self.subscribers {
+		peer.WriteMessage(frames)
+	}
+}